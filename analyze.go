@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+const _analyzeUsage = `Report how much each layer contributes to the flattened image, and how
+much it wastes with files that are overwritten or deleted in later layers.
+
+Arguments:
+  <infile>:  Input Docker container. Tarball.
+
+Options:
+  --top <n>
+             Print the n largest wasted files. Default: 10.
+  --max-wasted <bytes>
+             Fail if more than this many bytes are wasted, e.g. to keep
+             image bloat in check in CI. Default: no limit.
+  --json
+             Print JSON instead of text. All wasted files are included.
+` + _imageFlagsUsage
+
+var _analyze = &subcommand{
+	name:    "analyze",
+	args:    "<infile>",
+	nargs:   1,
+	summary: "Report the space wasted by every layer of an image.",
+	usage:   _analyzeUsage,
+	setup: func(flags *flag.FlagSet) func([]string) error {
+		var imgFlags imageFlags
+		c := &analyzeCommand{analyze: rootfs.Analyze, Stdout: os.Stdout}
+		flags.IntVar(&c.top, "top", 10, "")
+		flags.Int64Var(&c.maxWasted, "max-wasted", -1, "")
+		flags.BoolVar(&c.json, "json", false, "")
+		imgFlags.register(flags)
+		return func(args []string) error {
+			c.options = imgFlags.options()
+			return c.execute(args[0])
+		}
+	},
+}
+
+// analyzeCommand prints the layer waste of an image to Stdout.
+type analyzeCommand struct {
+	analyze   func(io.ReadSeeker, ...rootfs.Option) (*rootfs.Analysis, error)
+	options   []rootfs.Option
+	top       int
+	maxWasted int64
+	json      bool
+	Stdout    io.Writer
+}
+
+func (c *analyzeCommand) execute(infile string) (_err error) {
+	rd, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, rd.Close())
+	}()
+
+	a, err := c.analyze(rd, c.options...)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		enc := json.NewEncoder(c.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(a)
+	} else {
+		err = printAnalysis(c.Stdout, a, c.top)
+	}
+	if err != nil {
+		return err
+	}
+	if wasted := a.WastedSize(); c.maxWasted >= 0 && wasted > c.maxWasted {
+		return fmt.Errorf("%d bytes wasted, more than %d", wasted, c.maxWasted)
+	}
+	return nil
+}
+
+// printAnalysis prints a as text, with the top largest wasted files.
+func printAnalysis(w io.Writer, a *rootfs.Analysis, top int) error {
+	var size, wasted int64
+	fmt.Fprintf(w, "Layers:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  #\tSIZE\tKEPT\tOVERWRITTEN\tDELETED\tNAME\n")
+	for _, l := range a.Layers {
+		fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%s\t%s\n",
+			l.Layer, humanBytes(l.Size), humanBytes(l.Kept),
+			humanBytes(l.Overwritten), humanBytes(l.Deleted), l.Name)
+		size += l.Size
+		wasted += l.Overwritten + l.Deleted
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nTotal: %s, wasted: %s", humanBytes(size), humanBytes(wasted))
+	if size > 0 {
+		fmt.Fprintf(w, " (%.1f%%)", 100*float64(wasted)/float64(size))
+	}
+	fmt.Fprintf(w, "\n")
+
+	if top <= 0 || len(a.Wasted) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "\nLargest wasted files:\n")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  SIZE\tLAYER\tREASON\tNAME\n")
+	for i, f := range a.Wasted {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\n", humanBytes(f.Size), f.Layer, f.Reason, f.Name)
+	}
+	return tw.Flush()
+}
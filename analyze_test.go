@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+func TestAnalyze(t *testing.T) {
+	infile := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(infile, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := &rootfs.Analysis{
+		Layers: []rootfs.LayerWaste{
+			{Name: "blobs/sha256/0", Entries: 3, Size: 3072, Overwritten: 1024, Deleted: 1024, Kept: 1024},
+			{Layer: 1, Name: "blobs/sha256/1", Entries: 1, Size: 1024, Kept: 1024},
+		},
+		Wasted: []rootfs.WastedFile{
+			{Name: "var/cache/apk", Layer: 0, Size: 1024, Reason: rootfs.WasteDeleted},
+			{Name: "etc/passwd", Layer: 0, Size: 1024, Reason: rootfs.WasteOverwritten},
+		},
+	}
+	analyze := func(io.ReadSeeker, ...rootfs.Option) (*rootfs.Analysis, error) {
+		return a, nil
+	}
+
+	tests := []struct {
+		name      string
+		json      bool
+		top       int
+		maxWasted int64
+		want      []string
+		notWant   []string
+		wantErr   string
+	}{
+		{
+			name:      "text",
+			top:       1,
+			maxWasted: -1,
+			want: []string{
+				"  0  3.0 KiB  1.0 KiB  1.0 KiB      1.0 KiB  blobs/sha256/0\n",
+				"Total: 4.0 KiB, wasted: 2.0 KiB (50.0%)\n",
+				"  1.0 KiB  0      deleted  var/cache/apk\n",
+			},
+			notWant: []string{"etc/passwd"},
+		},
+		{
+			name:      "json",
+			json:      true,
+			maxWasted: -1,
+			want: []string{
+				`"overwritten": 1024,`,
+				`"reason": "overwritten"`,
+			},
+		},
+		{
+			name:      "over the limit",
+			top:       10,
+			maxWasted: 2047,
+			wantErr:   "2048 bytes wasted, more than 2047",
+		},
+		{
+			name:      "at the limit",
+			top:       10,
+			maxWasted: 2048,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			c := &analyzeCommand{
+				analyze:   analyze,
+				top:       tt.top,
+				maxWasted: tt.maxWasted,
+				json:      tt.json,
+				Stdout:    &stdout,
+			}
+			err := c.execute(infile)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("expected %q in output:\n%s", want, stdout.String())
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(stdout.String(), notWant) {
+					t.Errorf("unexpected %q in output:\n%s", notWant, stdout.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+const _catUsage = `Write a file of an image to stdout.
+
+Arguments:
+  <infile>:  Input Docker container. Tarball.
+  <path>:    Path of the file in the image. Symlinks are followed.
+
+Options:
+` + _imageFlagsUsage
+
+var _cat = &subcommand{
+	name:    "cat",
+	args:    "<infile> <path>",
+	nargs:   2,
+	summary: "Write a file of an image to stdout.",
+	usage:   _catUsage,
+	setup: func(flags *flag.FlagSet) func([]string) error {
+		var imgFlags imageFlags
+		imgFlags.register(flags)
+		return func(args []string) error {
+			c := &catCommand{
+				lookup:  rootfs.Lookup,
+				options: imgFlags.options(),
+				Stdout:  os.Stdout,
+			}
+			return c.execute(args[0], args[1])
+		}
+	},
+}
+
+// catCommand writes a file from an image to Stdout.
+type catCommand struct {
+	lookup  func(io.ReadSeeker, string, rootfs.WalkFunc, ...rootfs.Option) error
+	options []rootfs.Option
+	Stdout  io.Writer
+}
+
+func (c *catCommand) execute(infile string, name string) (_err error) {
+	rd, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, rd.Close())
+	}()
+
+	return c.lookup(rd, name, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag == tar.TypeDir {
+			return fmt.Errorf("%s: is a directory", name)
+		}
+		_, err := io.Copy(c.Stdout, r)
+		return err
+	}, c.options...)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+func TestCat(t *testing.T) {
+	infile := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(infile, []byte("image"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lookup := func(typeflag byte) func(io.ReadSeeker, string, rootfs.WalkFunc, ...rootfs.Option) error {
+		return func(rd io.ReadSeeker, name string, fn rootfs.WalkFunc, _ ...rootfs.Option) error {
+			image, err := io.ReadAll(rd)
+			if err != nil {
+				return err
+			}
+			hdr := &tar.Header{Name: name, Typeflag: typeflag}
+			return fn(hdr, strings.NewReader(string(image)+":"+name))
+		}
+	}
+
+	tests := []struct {
+		name     string
+		typeflag byte
+		want     string
+		wantErr  string
+	}{
+		{name: "file", typeflag: tar.TypeReg, want: "image:etc/passwd"},
+		{name: "directory", typeflag: tar.TypeDir, wantErr: "etc/passwd: is a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			c := &catCommand{lookup: lookup(tt.typeflag), Stdout: &stdout}
+			err := c.execute(infile, "etc/passwd")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("want != got: %s != %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := stdout.String(); got != tt.want {
+				t.Errorf("want != got: %q != %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+const _diffUsage = `Compare the flattened file systems of two images, and print the paths that
+are added (A), removed (D) or modified (M) in the second one. A path is
+modified if its type, mode, owner, link target or contents differ.
+
+Arguments:
+  <a>:  Old Docker container. Tarball.
+  <b>:  New Docker container. Tarball.
+
+Options:
+  --json
+             Print a JSON object per path instead of text.
+` + _imageFlagsUsage
+
+var _diff = &subcommand{
+	name:    "diff",
+	args:    "<a> <b>",
+	nargs:   2,
+	summary: "Compare the file systems of two images.",
+	usage:   _diffUsage,
+	setup: func(flags *flag.FlagSet) func([]string) error {
+		var imgFlags imageFlags
+		c := &diffCommand{diff: rootfs.Diff, Stdout: os.Stdout}
+		flags.BoolVar(&c.json, "json", false, "")
+		imgFlags.register(flags)
+		return func(args []string) error {
+			c.options = imgFlags.options()
+			return c.execute(args[0], args[1])
+		}
+	},
+}
+
+// diffCommand prints the differences between two images to Stdout.
+type diffCommand struct {
+	diff    func(io.ReadSeeker, io.ReadSeeker, ...rootfs.Option) ([]rootfs.Change, error)
+	options []rootfs.Option
+	json    bool
+	Stdout  io.Writer
+}
+
+func (c *diffCommand) execute(afile, bfile string) (_err error) {
+	a, err := os.Open(afile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, a.Close())
+	}()
+	b, err := os.Open(bfile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, b.Close())
+	}()
+
+	changes, err := c.diff(a, b, c.options...)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(c.Stdout)
+	for _, ch := range changes {
+		ch.Path = "/" + ch.Path
+		if c.json {
+			err = enc.Encode(ch)
+		} else {
+			err = printChange(c.Stdout, ch)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printChange prints ch as a line of text.
+func printChange(w io.Writer, ch rootfs.Change) error {
+	var err error
+	switch ch.Kind {
+	case rootfs.ChangeAdded:
+		_, err = fmt.Fprintf(w, "A %s\n", ch.Path)
+	case rootfs.ChangeRemoved:
+		_, err = fmt.Fprintf(w, "D %s\n", ch.Path)
+	default:
+		_, err = fmt.Fprintf(w, "M %s (%s)\n", ch.Path, strings.Join(ch.Fields, ", "))
+	}
+	return err
+}
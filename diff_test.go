@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	afile, bfile := filepath.Join(dir, "a.tar"), filepath.Join(dir, "b.tar")
+	for _, f := range []string{afile, bfile} {
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	changes := []rootfs.Change{
+		{Path: "etc/motd", Kind: rootfs.ChangeModified, Fields: []string{rootfs.FieldMode, rootfs.FieldContent}},
+		{Path: "etc/new", Kind: rootfs.ChangeAdded},
+		{Path: "etc/old", Kind: rootfs.ChangeRemoved},
+	}
+	diff := func(io.ReadSeeker, io.ReadSeeker, ...rootfs.Option) ([]rootfs.Change, error) {
+		return changes, nil
+	}
+
+	tests := []struct {
+		name string
+		json bool
+		want string
+	}{
+		{
+			name: "text",
+			want: "M /etc/motd (mode, content)\n" +
+				"A /etc/new\n" +
+				"D /etc/old\n",
+		},
+		{
+			name: "json",
+			json: true,
+			want: `{"path":"/etc/motd","kind":"modified","fields":["mode","content"]}` + "\n" +
+				`{"path":"/etc/new","kind":"added"}` + "\n" +
+				`{"path":"/etc/old","kind":"removed"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			c := &diffCommand{diff: diff, json: tt.json, Stdout: &stdout}
+			if err := c.execute(afile, bfile); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := stdout.String(); got != tt.want {
+				t.Errorf("want != got:\n%s\n%s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+const _explainUsage = `Explain why a path is in the flattened image or missing from it: print the
+entries of every layer that add, replace or hide it, and what undocker does
+with each of them.
+
+Arguments:
+  <infile>:  Input Docker container. Tarball.
+  <path>:    Path in the image. Symlinks are not followed.
+
+Options:
+  --json
+             Print JSON instead of text.
+` + _imageFlagsUsage
+
+var _explain = &subcommand{
+	name:    "explain",
+	args:    "<infile> <path>",
+	nargs:   2,
+	summary: "Explain why a path is in an image or missing from it.",
+	usage:   _explainUsage,
+	setup: func(flags *flag.FlagSet) func([]string) error {
+		var imgFlags imageFlags
+		c := &explainCommand{explain: rootfs.Explain, Stdout: os.Stdout}
+		flags.BoolVar(&c.json, "json", false, "")
+		imgFlags.register(flags)
+		return func(args []string) error {
+			c.options = imgFlags.options()
+			return c.execute(args[0], args[1])
+		}
+	},
+}
+
+// explainCommand prints the history of a path in an image to Stdout.
+type explainCommand struct {
+	explain func(io.ReadSeeker, string, ...rootfs.Option) (*rootfs.Trace, error)
+	options []rootfs.Option
+	json    bool
+	Stdout  io.Writer
+}
+
+func (c *explainCommand) execute(infile string, name string) (_err error) {
+	rd, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, rd.Close())
+	}()
+
+	t, err := c.explain(rd, name, c.options...)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		enc := json.NewEncoder(c.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	}
+	printTrace(c.Stdout, t)
+	return nil
+}
+
+// printTrace prints t as text.
+func printTrace(w io.Writer, t *rootfs.Trace) {
+	fmt.Fprintf(w, "%s:\n", t.Path)
+	if len(t.Events) == 0 {
+		fmt.Fprintf(w, "  not in any layer\n")
+	}
+	for _, ev := range t.Events {
+		fmt.Fprintf(w, "  layer %d (%s): %s %s", ev.Layer, ev.LayerName, ev.Action, ev.Name)
+		switch ev.Fate {
+		case "":
+		case rootfs.FateKept:
+			fmt.Fprintf(w, ": kept")
+		case rootfs.FateOverwritten:
+			fmt.Fprintf(w, ": overwritten by %s in layer %d", ev.By, ev.ByLayer)
+		case rootfs.FateWhiteout:
+			fmt.Fprintf(w, ": hidden by %s in layer %d", ev.By, ev.ByLayer)
+		case rootfs.FateOpaque:
+			fmt.Fprintf(w, ": hidden by opaque directory %s in layer %d", ev.By, ev.ByLayer)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	kept := t.Kept()
+	if len(kept) == 0 {
+		fmt.Fprintf(w, "Not in the flattened image.\n")
+		return
+	}
+	ev := kept[len(kept)-1]
+	fmt.Fprintf(w, "In the flattened image, from layer %d (%s).\n", ev.Layer, ev.LayerName)
+}
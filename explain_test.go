@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+func TestExplain(t *testing.T) {
+	infile := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(infile, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	traces := map[string]*rootfs.Trace{
+		"etc/shadow": {
+			Path: "etc/shadow",
+			Events: []rootfs.TraceEvent{
+				{
+					Layer:     0,
+					LayerName: "blobs/sha256/0",
+					Name:      "etc/shadow",
+					Action:    rootfs.ActionAdd,
+					Fate:      rootfs.FateWhiteout,
+					ByLayer:   1,
+					By:        "etc/.wh.shadow",
+				},
+				{
+					Layer:     1,
+					LayerName: "blobs/sha256/1",
+					Name:      "etc/.wh.shadow",
+					Action:    rootfs.ActionWhiteout,
+				},
+			},
+		},
+		"etc/passwd": {
+			Path: "etc/passwd",
+			Events: []rootfs.TraceEvent{{
+				Layer:     1,
+				LayerName: "blobs/sha256/1",
+				Name:      "etc/passwd",
+				Action:    rootfs.ActionAdd,
+				Fate:      rootfs.FateKept,
+			}},
+		},
+		"etc/group": {Path: "etc/group"},
+	}
+	explain := func(_ io.ReadSeeker, name string, _ ...rootfs.Option) (*rootfs.Trace, error) {
+		return traces[name], nil
+	}
+
+	tests := []struct {
+		name string
+		json bool
+		want string
+	}{
+		{
+			name: "etc/shadow",
+			want: "etc/shadow:\n" +
+				"  layer 0 (blobs/sha256/0): add etc/shadow: hidden by etc/.wh.shadow in layer 1\n" +
+				"  layer 1 (blobs/sha256/1): whiteout etc/.wh.shadow\n" +
+				"Not in the flattened image.\n",
+		},
+		{
+			name: "etc/passwd",
+			want: "etc/passwd:\n" +
+				"  layer 1 (blobs/sha256/1): add etc/passwd: kept\n" +
+				"In the flattened image, from layer 1 (blobs/sha256/1).\n",
+		},
+		{
+			name: "etc/group",
+			want: "etc/group:\n" +
+				"  not in any layer\n" +
+				"Not in the flattened image.\n",
+		},
+		{
+			name: "etc/passwd",
+			json: true,
+			want: `"fate": "kept"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			c := &explainCommand{explain: explain, json: tt.json, Stdout: &stdout}
+			if err := c.execute(infile, tt.name); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(stdout.String(), tt.want) {
+				t.Errorf("expected %q in output:\n%s", tt.want, stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+const _inspectUsage = `Print the manifest entry, configuration, history and layers of an image.
+
+Arguments:
+  <infile>:  Input Docker container. Tarball.
+
+Options:
+  --json
+             Print JSON instead of text.
+` + _imageFlagsUsage
+
+var _inspect = &subcommand{
+	name:    "inspect",
+	args:    "<infile>",
+	nargs:   1,
+	summary: "Print the manifest, configuration and layers of an image.",
+	usage:   _inspectUsage,
+	setup: func(flags *flag.FlagSet) func([]string) error {
+		var imgFlags imageFlags
+		c := &inspectCommand{inspect: rootfs.Inspect, Stdout: os.Stdout}
+		flags.BoolVar(&c.json, "json", false, "")
+		imgFlags.register(flags)
+		return func(args []string) error {
+			c.options = imgFlags.options()
+			return c.execute(args[0])
+		}
+	},
+}
+
+// inspectCommand prints what is in an image to Stdout.
+type inspectCommand struct {
+	inspect func(io.ReadSeeker, ...rootfs.Option) (*rootfs.ImageInfo, error)
+	options []rootfs.Option
+	json    bool
+	Stdout  io.Writer
+}
+
+func (c *inspectCommand) execute(infile string) (_err error) {
+	rd, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, rd.Close())
+	}()
+
+	info, err := c.inspect(rd, c.options...)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		enc := json.NewEncoder(c.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	return printImageInfo(c.Stdout, info)
+}
+
+// printImageInfo prints info as text.
+func printImageInfo(w io.Writer, info *rootfs.ImageInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Manifest:\n")
+	fmt.Fprintf(tw, "  Config:\t%s\n", info.Manifest.Config)
+	fmt.Fprintf(tw, "  RepoTags:\t%s\n", strings.Join(info.Manifest.RepoTags, ", "))
+
+	if cfg := info.Config; cfg != nil {
+		fmt.Fprintf(tw, "\nConfig:\n")
+		fmt.Fprintf(tw, "  Platform:\t%s/%s\n", cfg.OS, cfg.Architecture)
+		fmt.Fprintf(tw, "  User:\t%s\n", cfg.Config.User)
+		fmt.Fprintf(tw, "  WorkingDir:\t%s\n", cfg.Config.WorkingDir)
+		fmt.Fprintf(tw, "  Entrypoint:\t%s\n", formatArgs(cfg.Config.Entrypoint))
+		fmt.Fprintf(tw, "  Cmd:\t%s\n", formatArgs(cfg.Config.Cmd))
+		fmt.Fprintf(tw, "  ExposedPorts:\t%s\n", strings.Join(sortedKeys(cfg.Config.ExposedPorts), ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if cfg := info.Config; cfg != nil {
+		fmt.Fprintf(w, "  Env:\n")
+		for _, env := range cfg.Config.Env {
+			fmt.Fprintf(w, "    %s\n", env)
+		}
+		fmt.Fprintf(w, "  Labels:\n")
+		for _, k := range sortedKeys(cfg.Config.Labels) {
+			fmt.Fprintf(w, "    %s=%s\n", k, cfg.Config.Labels[k])
+		}
+	}
+
+	if info.Config != nil && len(info.Config.History) > 0 {
+		fmt.Fprintf(w, "\nHistory:\n")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "  #\tCREATED\tCREATED BY\n")
+		for i, h := range info.Config.History {
+			createdBy := h.CreatedBy
+			if h.EmptyLayer {
+				createdBy += " (empty layer)"
+			}
+			fmt.Fprintf(tw, "  %d\t%s\t%s\n", i, h.Created, createdBy)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "\nLayers:\n")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  #\tCOMPRESSION\tCOMPRESSED\tSIZE\tENTRIES\tNAME\n")
+	for i, l := range info.Layers {
+		fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%d\t%s\n",
+			i, l.Compression, humanBytes(l.CompressedSize), humanBytes(l.Size), l.Entries, l.Name)
+	}
+	return tw.Flush()
+}
+
+// formatArgs formats a command line like the JSON array in a Dockerfile.
+func formatArgs(args []string) string {
+	if args == nil {
+		return ""
+	}
+	b, _ := json.Marshal(args)
+	return string(b)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
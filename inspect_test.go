@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+func TestInspect(t *testing.T) {
+	infile := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(infile, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info := &rootfs.ImageInfo{
+		Manifest: rootfs.ManifestEntry{
+			Config:   "blobs/sha256/config",
+			RepoTags: []string{"app:latest"},
+			Layers:   []string{"blobs/sha256/0"},
+		},
+		Config: &rootfs.ImageConfig{
+			OS:           "linux",
+			Architecture: "amd64",
+			Config: rootfs.ContainerConfig{
+				Env:        []string{"PATH=/bin"},
+				Entrypoint: []string{"/app", "--serve"},
+			},
+			History: []rootfs.History{{CreatedBy: "COPY app"}},
+		},
+		Layers: []rootfs.LayerInfo{{
+			Name:           "blobs/sha256/0",
+			Compression:    "gzip",
+			CompressedSize: 1024,
+			Size:           4096,
+			Entries:        3,
+		}},
+	}
+	inspect := func(io.ReadSeeker, ...rootfs.Option) (*rootfs.ImageInfo, error) {
+		return info, nil
+	}
+
+	tests := []struct {
+		name string
+		json bool
+		want []string
+	}{
+		{
+			name: "text",
+			want: []string{
+				"  RepoTags:  app:latest\n",
+				"  Platform:      linux/amd64\n",
+				`  Entrypoint:    ["/app","--serve"]` + "\n",
+				"    PATH=/bin\n",
+				"  0           COPY app\n",
+				"  0  gzip         1.0 KiB     4.0 KiB  3        blobs/sha256/0\n",
+			},
+		},
+		{
+			name: "json",
+			json: true,
+			want: []string{
+				`"RepoTags": [`,
+				`"compressed_size": 1024,`,
+				`"created_by": "COPY app"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			c := &inspectCommand{inspect: inspect, json: tt.json, Stdout: &stdout}
+			if err := c.execute(infile); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("expected %q in output:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
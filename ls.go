@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+const _lsUsage = `List the files of the flattened image, with the layers they come from.
+
+Arguments:
+  <infile>:  Input Docker container. Tarball.
+  <path>:    Only list these files, and the files in these directories.
+
+Options:
+  --long
+             Print the type, mode, owner, size and the index and digest of
+             the layer of every file.
+  --json
+             Print a JSON object per file, with all of the above.
+` + _imageFlagsUsage
+
+var _ls = &subcommand{
+	name:     "ls",
+	args:     "<infile> [<path>...]",
+	nargs:    1,
+	variadic: true,
+	summary:  "List the files of an image, with the layers they come from.",
+	usage:    _lsUsage,
+	setup: func(flags *flag.FlagSet) func([]string) error {
+		var imgFlags imageFlags
+		c := &lsCommand{list: rootfs.List, Stdout: os.Stdout}
+		flags.BoolVar(&c.long, "long", false, "")
+		flags.BoolVar(&c.json, "json", false, "")
+		imgFlags.register(flags)
+		return func(args []string) error {
+			c.options = imgFlags.options()
+			return c.execute(args[0], args[1:])
+		}
+	},
+}
+
+// lsCommand lists the files of an image to Stdout.
+type lsCommand struct {
+	list    func(io.ReadSeeker, ...rootfs.Option) ([]rootfs.Entry, error)
+	options []rootfs.Option
+	long    bool
+	json    bool
+	Stdout  io.Writer
+}
+
+// lsJSON is a line of `ls --json`.
+type lsJSON struct {
+	Path      string `json:"path"`
+	Type      string `json:"type"`
+	Mode      string `json:"mode"`
+	UID       int    `json:"uid"`
+	GID       int    `json:"gid"`
+	Size      int64  `json:"size"`
+	Linkname  string `json:"linkname,omitempty"`
+	Layer     int    `json:"layer"`
+	LayerName string `json:"layer_name"`
+	Digest    string `json:"digest,omitempty"`
+}
+
+func (c *lsCommand) execute(infile string, paths []string) (_err error) {
+	rd, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, rd.Close())
+	}()
+
+	entries, err := c.list(rd, c.options...)
+	if err != nil {
+		return err
+	}
+	for i, p := range paths {
+		paths[i] = path.Clean("/" + p)
+	}
+
+	tw := tabwriter.NewWriter(c.Stdout, 0, 0, 2, ' ', 0)
+	enc := json.NewEncoder(c.Stdout)
+	for _, e := range entries {
+		hdr := e.Header
+		name := path.Clean("/" + hdr.Name)
+		if !matchPaths(paths, name) {
+			continue
+		}
+		switch {
+		case c.json:
+			err = enc.Encode(lsJSON{
+				Path:      name,
+				Type:      typeName(hdr.Typeflag),
+				Mode:      fmt.Sprintf("%04o", hdr.Mode&07777),
+				UID:       hdr.Uid,
+				GID:       hdr.Gid,
+				Size:      hdr.Size,
+				Linkname:  hdr.Linkname,
+				Layer:     e.Layer,
+				LayerName: e.LayerName,
+				Digest:    e.Digest,
+			})
+		case c.long:
+			digest := e.Digest
+			if digest == "" {
+				digest = e.LayerName
+			}
+			_, err = fmt.Fprintf(tw, "%s\t%d/%d\t%d\t%d\t%s\t%s\n",
+				hdr.FileInfo().Mode(), hdr.Uid, hdr.Gid, hdr.Size,
+				e.Layer, shortDigest(digest), formatName(name, hdr))
+		default:
+			_, err = fmt.Fprintln(c.Stdout, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// matchPaths returns whether name is one of paths, or in one of them. No
+// paths match everything.
+func matchPaths(paths []string, name string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		if p == "/" || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// formatName formats the name of an entry like `ls -l` does.
+func formatName(name string, hdr *tar.Header) string {
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		return name + " -> " + hdr.Linkname
+	case tar.TypeLink:
+		return name + " link to " + path.Clean("/"+hdr.Linkname)
+	}
+	return name
+}
+
+// shortDigest shortens a digest to 12 hex digits, like docker does.
+func shortDigest(digest string) string {
+	if algo, hex, ok := strings.Cut(digest, ":"); ok && len(hex) > 12 {
+		return algo + ":" + hex[:12]
+	}
+	return digest
+}
+
+// typeName names a tar entry type.
+func typeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg, tar.TypeGNUSparse:
+		return "file"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return fs.ModeIrregular.String()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+func TestLs(t *testing.T) {
+	infile := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(infile, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	list := func(io.ReadSeeker, ...rootfs.Option) ([]rootfs.Entry, error) {
+		return []rootfs.Entry{
+			{
+				Header:    &tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0755},
+				LayerName: "blobs/sha256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				Digest:    digest,
+			},
+			{
+				Header: &tar.Header{Name: "etc/os-release", Typeflag: tar.TypeSymlink,
+					Linkname: "../usr/lib/os-release", Mode: 0777},
+				Layer:     1,
+				LayerName: "blobs/layer1/layer",
+			},
+			{
+				Header: &tar.Header{Name: "etcetera", Typeflag: tar.TypeReg,
+					Mode: 0644, Uid: 1, Gid: 2, Size: 3},
+				Layer:     1,
+				LayerName: "blobs/layer1/layer",
+			},
+		}, nil
+	}
+
+	tests := []struct {
+		name  string
+		long  bool
+		json  bool
+		paths []string
+		want  string
+	}{
+		{
+			name: "short",
+			want: "/etc\n/etc/os-release\n/etcetera\n",
+		},
+		{
+			name:  "filtered",
+			paths: []string{"etc"},
+			want:  "/etc\n/etc/os-release\n",
+		},
+		{
+			name: "long",
+			long: true,
+			want: "drwxr-xr-x  0/0  0  0  sha256:0123456789ab  /etc\n" +
+				"Lrwxrwxrwx  0/0  0  1  blobs/layer1/layer   /etc/os-release -> ../usr/lib/os-release\n" +
+				"-rw-r--r--  1/2  3  1  blobs/layer1/layer   /etcetera\n",
+		},
+		{
+			name:  "json",
+			json:  true,
+			paths: []string{"/etcetera"},
+			want: `{"path":"/etcetera","type":"file","mode":"0644","uid":1,"gid":2,"size":3,` +
+				`"layer":1,"layer_name":"blobs/layer1/layer"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			c := &lsCommand{list: list, long: tt.long, json: tt.json, Stdout: &stdout}
+			if err := c.execute(infile, tt.paths); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := stdout.String(); got != tt.want {
+				t.Errorf("want != got:\n%s\n!=\n%s", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,13 +1,16 @@
-// Package main is a simple command-line application on top of rootfs.Flatten.
+// Package main is a simple command-line application on top of rootfs.
 package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 
 	"git.jakstys.lt/motiejus/undocker/rootfs"
 )
@@ -16,42 +19,243 @@ var Version = "unknown"
 var VersionHash = "unknown"
 
 const _usage = `Usage:
-  %s <infile> <outfile>
+  %[1]s [flatten] [options] <infile> <outfile>
+  %[1]s <command> [options] <arguments>
 
-Flatten a Docker container image to a root file system.
+Commands:
+%[2]s
+Run '%[1]s <command> -h' for the arguments and options of a command.
+
+undocker %[3]s (%[4]s)
+Built with %[5]s
+`
+
+const _flattenUsage = `Flatten a Docker container image to a root file system.
 
 Arguments:
   <infile>:  Input Docker container. Tarball.
   <outfile>: Output tarball, the root file system. '-' is stdout.
 
-undocker %s (%s)
-Built with %s
+Options:
+  --map-uid <container id>:<host id>:<size>
+             Shift user ids in the image to the host range. Repeatable.
+  --map-gid <container id>:<host id>:<size>
+             Shift group ids in the image to the host range. Repeatable.
+  --nobody <uid>[:<gid>]
+             Replace ids that are not covered by --map-uid or --map-gid
+             with these ids instead of failing.
+  --resolve-names
+             Set user and group names of every file from the image's own
+             /etc/passwd and /etc/group. Cannot be combined with the id
+             mapping options.
+  --include <pattern>
+             Only write the paths that match pattern, and everything in the
+             directories that do. '**' matches any number of directories,
+             e.g. /usr/lib/**/*.so. Repeatable.
+  --exclude <pattern>
+             Do not write the paths that match pattern, nor anything in the
+             directories that do, e.g. /usr/share/doc. Takes precedence over
+             --include. Repeatable.
+  --include-from <file>, --exclude-from <file>
+             Read --include or --exclude patterns from file, one per line.
+             Blank lines and lines starting with '#' are skipped.
+  --root <dir>
+             Make dir of the image the root of <outfile>, e.g. /opt/dist.
+             Links that point outside of it are left out; --warnings
+             reports them.
+  --prefix <dir>
+             Put every file of <outfile> in dir, e.g. rootfs for LXC. The
+             directories of dir are written too.
+  --layers <range>
+             Flatten only some of the layers: <first>..<last>, ..<last>,
+             <first>.. or a single layer. A layer is its index counting
+             from 0, its diff_id, or the digest or name of its blob.
+  --mtree <file>
+             Write an mtree(5) specification of <outfile> with the ownership
+             and modes of all files.
+  --progress <auto|tty|json|none>
+             Print progress to stderr. 'auto' (default) prints it only if
+             stderr is a terminal; 'json' prints periodic JSON lines.
+` + _imageFlagsUsage
+
+// _imageFlagsUsage documents imageFlags.
+const _imageFlagsUsage = `  --warnings
+             Print anomalies in the image, like whiteouts in the lowest
+             layer or duplicate entries, to stderr.
+  --strict
+             Fail on the first anomaly in the image.
 `
 
+// errUsage means that the command line is invalid. The usage is already
+// printed.
+var errUsage = errors.New("invalid usage")
+
+// subcommand is a command of undocker.
+type subcommand struct {
+	name string
+	// args is the synopsis of the arguments, and nargs their number. If
+	// variadic, more arguments are accepted.
+	args     string
+	nargs    int
+	variadic bool
+	// summary is a one-line description, and usage the full one, with the
+	// arguments and options.
+	summary string
+	usage   string
+	// setup registers the flags of the command, and returns a function
+	// that runs it with the positional arguments.
+	setup func(flags *flag.FlagSet) func(args []string) error
+}
+
+// _flatten is the default command.
+var _flatten = &subcommand{
+	name:    "flatten",
+	args:    "<infile> <outfile>",
+	nargs:   2,
+	summary: "Flatten an image to a root file system tarball (default).",
+	usage:   _flattenUsage,
+	setup:   setupFlatten,
+}
+
+// _subcommands are all commands, in the order they are listed in the usage.
+var _subcommands = []*subcommand{_flatten, _inspect, _ls, _cat, _diff, _verify, _explain, _analyze}
+
 func main() {
 	runtime.GOMAXPROCS(1) // no need to create that many threads
 
-	if len(os.Args) != 3 {
-		fmt.Fprintf(os.Stderr, _usage,
-			filepath.Base(os.Args[0]),
-			Version,
-			VersionHash,
-			runtime.Version(),
-		)
+	if err := run(os.Args[1:], os.Stderr); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
 		os.Exit(1)
 	}
+}
 
-	c := &command{flattener: rootfs.Flatten, Stdout: os.Stdout}
-	if err := c.execute(os.Args[1], os.Args[2]); err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+// run runs the command in args, writing the usage to stderr if requested or
+// invalid.
+func run(args []string, stderr io.Writer) error {
+	prog := filepath.Base(os.Args[0])
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		var cmds strings.Builder
+		for _, cmd := range _subcommands {
+			fmt.Fprintf(&cmds, "  %-9s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(stderr, _usage, prog, cmds.String(), Version, VersionHash, runtime.Version())
+		if len(args) == 0 {
+			return errUsage
+		}
+		return nil
+	}
+
+	// `undocker <infile> <outfile>` is short for `undocker flatten ...`
+	cmd := _flatten
+	for _, c := range _subcommands {
+		if args[0] == c.name {
+			cmd, args = c, args[1:]
+			break
+		}
+	}
+
+	flags := flag.NewFlagSet(prog+" "+cmd.name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage:\n  %s %s [options] %s\n\n%s", prog, cmd.name, cmd.args, cmd.usage)
+	}
+	runCmd := cmd.setup(flags)
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return errUsage
+	}
+	if flags.NArg() < cmd.nargs || flags.NArg() > cmd.nargs && !cmd.variadic {
+		flags.Usage()
+		return errUsage
+	}
+	return runCmd(flags.Args())
+}
+
+// setupFlatten sets up the flatten command.
+func setupFlatten(flags *flag.FlagSet) func([]string) error {
+	var uidMap, gidMap idMapFlag
+	var nobody nobodyFlag
+	var resolveNames bool
+	var progressMode string
+	var include, exclude, includeFrom, excludeFrom stringsFlag
+	var layers layersFlag
+	var root, prefix string
+	var imgFlags imageFlags
+	c := &command{flattener: rootfs.FlattenWithOptions, Stdout: os.Stdout}
+	flags.Var(&uidMap, "map-uid", "")
+	flags.Var(&gidMap, "map-gid", "")
+	flags.Var(&nobody, "nobody", "")
+	flags.BoolVar(&resolveNames, "resolve-names", false, "")
+	flags.Var(&include, "include", "")
+	flags.Var(&exclude, "exclude", "")
+	flags.Var(&includeFrom, "include-from", "")
+	flags.Var(&excludeFrom, "exclude-from", "")
+	flags.Var(&layers, "layers", "")
+	flags.StringVar(&root, "root", "", "")
+	flags.StringVar(&prefix, "prefix", "", "")
+	flags.StringVar(&c.mtree, "mtree", "", "")
+	flags.StringVar(&progressMode, "progress", _progressAuto, "")
+	imgFlags.register(flags)
+
+	return func(args []string) error {
+		// mapped ids lose their names, so resolving them would do nothing.
+		if resolveNames && (len(uidMap) > 0 || len(gidMap) > 0 || nobody.set) {
+			return errors.New("--resolve-names cannot be combined with --map-uid, --map-gid or --nobody")
+		}
+		c.options = append(c.options, rootfs.WithIDMap(uidMap, gidMap))
+		if nobody.set {
+			c.options = append(c.options, rootfs.WithNobody(nobody.uid, nobody.gid))
+		}
+		if resolveNames {
+			c.options = append(c.options, rootfs.WithResolvedNames())
+		}
+		for _, fname := range includeFrom {
+			patterns, err := readPatterns(fname)
+			if err != nil {
+				return err
+			}
+			include = append(include, patterns...)
+		}
+		for _, fname := range excludeFrom {
+			patterns, err := readPatterns(fname)
+			if err != nil {
+				return err
+			}
+			exclude = append(exclude, patterns...)
+		}
+		c.options = append(c.options, rootfs.WithInclude(include...), rootfs.WithExclude(exclude...))
+		if layers.set {
+			c.options = append(c.options, rootfs.WithLayers(layers.first, layers.last))
+		}
+		if root != "" {
+			c.options = append(c.options, rootfs.WithRoot(root))
+		}
+		if prefix != "" {
+			c.options = append(c.options, rootfs.WithPrefix(prefix))
+		}
+		c.options = append(c.options, imgFlags.options()...)
+		printer, err := newProgressPrinter(progressMode, os.Stderr, isTerminal(os.Stderr))
+		if err != nil {
+			return err
+		}
+		if printer != nil {
+			c.options = append(c.options, rootfs.WithProgress(printer.report))
+			defer printer.finish()
+		}
+		return c.execute(args[0], args[1])
 	}
-	os.Exit(0)
 }
 
 type command struct {
-	flattener func(io.ReadSeeker, io.Writer) error
-	Stdout    io.Writer
+	flattener func(io.ReadSeeker, io.Writer, ...rootfs.Option) error
+	options   []rootfs.Option
+	// mtree is the path of the mtree(5) specification to write, if any.
+	mtree  string
+	Stdout io.Writer
 }
 
 func (c *command) execute(infile string, outfile string) (_err error) {
@@ -81,5 +285,164 @@ func (c *command) execute(infile string, outfile string) (_err error) {
 		out = outf
 	}
 
-	return c.flattener(rd, out)
+	opts := c.options
+	if c.mtree != "" {
+		mtreef, err := os.Create(c.mtree)
+		if err != nil {
+			return fmt.Errorf("create: %w", err)
+		}
+		defer func() {
+			_err = errors.Join(_err, mtreef.Close())
+			if _err != nil {
+				_err = errors.Join(_err, os.Remove(c.mtree))
+			}
+		}()
+		opts = append(opts[:len(opts):len(opts)], rootfs.WithMtree(mtreef))
+	}
+
+	return c.flattener(rd, out, opts...)
+}
+
+// readPatterns reads the glob patterns in file fname.
+func readPatterns(fname string) (_ []string, _err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_err = errors.Join(_err, f.Close())
+	}()
+	patterns, err := rootfs.ReadPatterns(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return patterns, nil
+}
+
+// idMapFlag is a repeatable flag of rootfs.IDMapping values.
+type idMapFlag []rootfs.IDMapping
+
+func (f *idMapFlag) String() string {
+	ret := make([]string, len(*f))
+	for i, m := range *f {
+		ret[i] = m.String()
+	}
+	return strings.Join(ret, ",")
+}
+
+func (f *idMapFlag) Set(s string) error {
+	m, err := rootfs.ParseIDMapping(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, m)
+	return nil
+}
+
+// nobodyFlag is a <uid>[:<gid>] flag. gid defaults to uid.
+type nobodyFlag struct {
+	set      bool
+	uid, gid int
+}
+
+func (f *nobodyFlag) String() string {
+	if !f.set {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", f.uid, f.gid)
+}
+
+func (f *nobodyFlag) Set(s string) error {
+	uidStr, gidStr, found := strings.Cut(s, ":")
+	if !found {
+		gidStr = uidStr
+	}
+	uid, err := strconv.Atoi(uidStr)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q", uidStr)
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q", gidStr)
+	}
+	*f = nobodyFlag{set: true, uid: uid, gid: gid}
+	return nil
+}
+
+// layersFlag is a range of layers: "<first>..<last>", where either can be
+// left out, or a single layer.
+type layersFlag struct {
+	set         bool
+	first, last string
+}
+
+func (f *layersFlag) String() string {
+	if !f.set {
+		return ""
+	}
+	if f.first == f.last {
+		return f.first
+	}
+	return f.first + ".." + f.last
+}
+
+func (f *layersFlag) Set(s string) error {
+	first, last, found := strings.Cut(s, "..")
+	if !found {
+		last = first
+	}
+	if first == "" && last == "" {
+		return fmt.Errorf("invalid layer range %q", s)
+	}
+	*f = layersFlag{set: true, first: first, last: last}
+	return nil
+}
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// imageFlags are the flags of every command that reads an image.
+type imageFlags struct {
+	warnings bool
+	strict   bool
+}
+
+func (f *imageFlags) register(flags *flag.FlagSet) {
+	flags.BoolVar(&f.warnings, "warnings", false, "")
+	flags.BoolVar(&f.strict, "strict", false, "")
+}
+
+// options returns the rootfs options of the flags.
+func (f *imageFlags) options() []rootfs.Option {
+	var ret []rootfs.Option
+	if f.warnings {
+		ret = append(ret, rootfs.WithWarnings(printWarning(os.Stderr)))
+	}
+	if f.strict {
+		ret = append(ret, rootfs.WithStrict())
+	}
+	return ret
+}
+
+// printWarning returns a rootfs.WithWarnings callback that prints the
+// warnings to w.
+func printWarning(w io.Writer) func(rootfs.Warning) {
+	return func(warning rootfs.Warning) {
+		fmt.Fprintf(w, "Warning: %s (%s)\n", warning, warning.Kind)
+	}
+}
+
+// isTerminal returns whether f is a character device, like a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
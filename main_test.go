@@ -3,11 +3,15 @@ package main
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
 )
 
 func TestExecute(t *testing.T) {
@@ -16,9 +20,11 @@ func TestExecute(t *testing.T) {
 	tests := []struct {
 		name      string
 		fixture   func(*testing.T, string)
-		flattener func(io.ReadSeeker, io.Writer) error
+		flattener func(io.ReadSeeker, io.Writer, ...rootfs.Option) error
+		options   []rootfs.Option
 		infile    string
 		outfile   string
+		mtree     string
 		wantErr   string
 		assertion func(*testing.T, string)
 	}{
@@ -69,6 +75,48 @@ func TestExecute(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:   "options are passed to the flattener",
+			infile: "t40-in.txt",
+			fixture: func(t *testing.T, dir string) {
+				fname := filepath.Join(dir, "t40-in.txt")
+				if err := os.WriteFile(fname, _foo, 0644); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+			options:   []rootfs.Option{rootfs.WithResolvedNames()},
+			mtree:     "t40.mtree",
+			flattener: flattenWantOptions(2),
+			outfile:   "t40-out.txt",
+			assertion: func(t *testing.T, dir string) {
+				if _, err := os.Stat(filepath.Join(dir, "t40.mtree")); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name:   "bad flattener should remove the mtree file",
+			infile: "t50-in.txt",
+			fixture: func(t *testing.T, dir string) {
+				fname := filepath.Join(dir, "t50-in.txt")
+				if err := os.WriteFile(fname, _foo, 0644); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+			flattener: flattenBad,
+			outfile:   "-",
+			mtree:     "t50.mtree",
+			wantErr:   "some error",
+			assertion: func(t *testing.T, dir string) {
+				d, err := os.ReadDir(dir)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(d) != 1 {
+					t.Fatalf("expected 1 entry, got %d", len(d))
+				}
+			},
+		},
 		{
 			name:    "infile does not exist",
 			infile:  "t3-does-not-exist.txt",
@@ -97,7 +145,14 @@ func TestExecute(t *testing.T) {
 			}
 			inf := filepath.Join(dir, tt.infile)
 
-			c := &command{Stdout: &stdout, flattener: tt.flattener}
+			c := &command{
+				Stdout:    &stdout,
+				flattener: tt.flattener,
+				options:   tt.options,
+			}
+			if tt.mtree != "" {
+				c.mtree = filepath.Join(dir, tt.mtree)
+			}
 			err := c.execute(inf, tt.outfile)
 
 			if tt.assertion != nil {
@@ -134,11 +189,200 @@ func TestExecute(t *testing.T) {
 	}
 }
 
-func flattenPassthrough(r io.ReadSeeker, w io.Writer) error {
+func flattenPassthrough(r io.ReadSeeker, w io.Writer, _ ...rootfs.Option) error {
 	_, err := io.Copy(w, r)
 	return err
 }
 
-func flattenBad(_ io.ReadSeeker, _ io.Writer) error {
+func flattenBad(_ io.ReadSeeker, _ io.Writer, _ ...rootfs.Option) error {
 	return errors.New("some error")
 }
+
+func flattenWantOptions(n int) func(io.ReadSeeker, io.Writer, ...rootfs.Option) error {
+	return func(r io.ReadSeeker, w io.Writer, opts ...rootfs.Option) error {
+		if len(opts) != n {
+			return fmt.Errorf("expected %d options, got %d", n, len(opts))
+		}
+		return flattenPassthrough(r, w)
+	}
+}
+
+func TestRun(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.tar")
+	tests := []struct {
+		name       string
+		args       []string
+		wantErr    string
+		wantStderr string
+	}{
+		{
+			name:       "no arguments",
+			args:       nil,
+			wantErr:    "invalid usage",
+			wantStderr: "Commands:\n  flatten ",
+		},
+		{
+			name:       "help",
+			args:       []string{"help"},
+			wantStderr: "Commands:\n  flatten ",
+		},
+		{
+			name:    "flatten alias",
+			args:    []string{"--strict", missing, "-"},
+			wantErr: "open " + missing + ": no such file or directory",
+		},
+		{
+			name:    "flatten",
+			args:    []string{"flatten", missing, "-"},
+			wantErr: "open " + missing + ": no such file or directory",
+		},
+		{
+			name:    "resolve names with mapped ids",
+			args:    []string{"--resolve-names", "--map-uid", "0:100000:65536", missing, "-"},
+			wantErr: "--resolve-names cannot be combined with --map-uid, --map-gid or --nobody",
+		},
+		{
+			name:       "wrong number of arguments",
+			args:       []string{"cat", missing},
+			wantErr:    "invalid usage",
+			wantStderr: " cat [options] <infile> <path>\n",
+		},
+		{
+			name:       "unknown flag",
+			args:       []string{"cat", "--foo", missing, "etc/passwd"},
+			wantErr:    "invalid usage",
+			wantStderr: "flag provided but not defined: -foo\n",
+		},
+		{
+			name:       "command help",
+			args:       []string{"flatten", "-h"},
+			wantStderr: "  --map-uid <container id>:<host id>:<size>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			err := run(tt.args, &stderr)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("want != got: %s != %v", tt.wantErr, err)
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("expected %q in stderr, got %q", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestIDMapFlag(t *testing.T) {
+	var f idMapFlag
+	for _, s := range []string{"0:100000:1000", "65534:165534:1"} {
+		if err := f.Set(s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if want, got := "0:100000:1000,65534:165534:1", f.String(); want != got {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+	if err := f.Set("0:1"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestPrintWarning(t *testing.T) {
+	var buf bytes.Buffer
+	printWarning(&buf)(rootfs.Warning{
+		Kind:  rootfs.WarningDuplicateEntry,
+		Layer: "blobs/layer0/layer",
+		Name:  "a",
+		Msg:   "duplicate entry",
+	})
+	want := "Warning: blobs/layer0/layer: a: duplicate entry (duplicate-entry)\n"
+	if got := buf.String(); want != got {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+}
+
+func TestNobodyFlag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "65534", want: "65534:65534"},
+		{in: "65534:65533", want: "65534:65533"},
+		{in: "nobody", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var f nobodyFlag
+			err := f.Set(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := f.String(); tt.want != got {
+				t.Errorf("want != got: %q != %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReadPatterns(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "exclude")
+	if err := os.WriteFile(fname, []byte("# docs\n/usr/share/doc\n/usr/share/man\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := readPatterns(fname)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "/usr/share/doc,/usr/share/man"; strings.Join(got, ",") != want {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+	if _, err := readPatterns(fname + ".missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestLayersFlag(t *testing.T) {
+	tests := []struct {
+		in          string
+		first, last string
+		wantErr     bool
+	}{
+		{in: "0..2", first: "0", last: "2"},
+		{in: "..sha256:abc", last: "sha256:abc"},
+		{in: "1..", first: "1"},
+		{in: "3", first: "3", last: "3"},
+		{in: "..", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var f layersFlag
+			err := f.Set(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if f.first != tt.first || f.last != tt.last {
+				t.Errorf("want != got: %q..%q != %q..%q", tt.first, tt.last, f.first, f.last)
+			}
+			if got := f.String(); got != tt.in {
+				t.Errorf("want != got: %q != %q", tt.in, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+const (
+	_progressAuto = "auto"
+	_progressTTY  = "tty"
+	_progressJSON = "json"
+	_progressNone = "none"
+)
+
+// progressPrinter renders rootfs.Progress either as a single, continuously
+// updated line on a terminal, or as periodic JSON lines.
+type progressPrinter struct {
+	w        io.Writer
+	json     bool
+	interval time.Duration
+	now      func() time.Time
+
+	last      time.Time
+	lastPhase rootfs.Phase
+	lastLayer int
+	// open is whether a line is printed on the terminal that is not ended
+	// yet.
+	open bool
+}
+
+// progressJSON is a JSON line printed by the progressPrinter
+type progressJSON struct {
+	Phase     string `json:"phase"`
+	Layer     int    `json:"layer"`
+	Layers    int    `json:"layers"`
+	LayerName string `json:"layer_name"`
+	BytesRead int64  `json:"bytes_read"`
+	Entries   int    `json:"entries"`
+}
+
+// newProgressPrinter returns a printer for the given --progress mode, or nil
+// if progress should not be printed.
+func newProgressPrinter(mode string, w io.Writer, isTTY bool) (*progressPrinter, error) {
+	p := &progressPrinter{w: w, now: time.Now, lastLayer: -1}
+	switch mode {
+	case _progressAuto:
+		if !isTTY {
+			return nil, nil
+		}
+		p.interval = 100 * time.Millisecond
+	case _progressTTY:
+		p.interval = 100 * time.Millisecond
+	case _progressJSON:
+		p.json = true
+		p.interval = time.Second
+	case _progressNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid progress mode %q", mode)
+	}
+	return p, nil
+}
+
+// report prints the progress at most once per interval, unless the phase
+// changed.
+func (p *progressPrinter) report(pr rootfs.Progress) {
+	now := p.now()
+	changed := pr.Phase != p.lastPhase
+	// JSON lines are meant for logs, so a new layer is not worth a line.
+	if !p.json {
+		changed = changed || pr.Layer != p.lastLayer
+	}
+	if !changed && now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last, p.lastPhase, p.lastLayer = now, pr.Phase, pr.Layer
+
+	if p.json {
+		b, _ := json.Marshal(progressJSON{
+			Phase:     pr.Phase.String(),
+			Layer:     pr.Layer,
+			Layers:    pr.Layers,
+			LayerName: pr.LayerName,
+			BytesRead: pr.BytesRead,
+			Entries:   pr.Entries,
+		})
+		fmt.Fprintf(p.w, "%s\n", b)
+		return
+	}
+
+	if pr.Phase == rootfs.PhaseDone {
+		fmt.Fprintf(p.w, "\r\033[Kdone: %d entries written, %s read\n",
+			pr.Entries, humanBytes(pr.BytesRead))
+		p.open = false
+		return
+	}
+	fmt.Fprintf(p.w, "\r\033[K%s layer %d/%d: %d entries written, %s read",
+		pr.Phase, pr.Layer+1, pr.Layers, pr.Entries, humanBytes(pr.BytesRead))
+	p.open = true
+}
+
+// finish ends the line on the terminal, if it is not ended yet, e.g. when
+// flattening fails, so that the error is not printed over it.
+func (p *progressPrinter) finish() {
+	if p.open {
+		fmt.Fprintln(p.w)
+		p.open = false
+	}
+}
+
+// humanBytes formats n with a binary unit, e.g. "1.5 MiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+func TestProgressPrinter(t *testing.T) {
+	events := []struct {
+		after time.Duration
+		p     rootfs.Progress
+	}{
+		{0, rootfs.Progress{Phase: rootfs.PhaseIndexing, Layer: 0, Layers: 2, LayerName: "a"}},
+		{10 * time.Millisecond, rootfs.Progress{Phase: rootfs.PhaseIndexing, Layer: 0, Layers: 2, LayerName: "a", BytesRead: 10}},
+		{10 * time.Millisecond, rootfs.Progress{Phase: rootfs.PhaseIndexing, Layer: 1, Layers: 2, LayerName: "b", BytesRead: 20}},
+		{10 * time.Millisecond, rootfs.Progress{Phase: rootfs.PhaseWriting, Layer: 0, Layers: 2, LayerName: "a", BytesRead: 30}},
+		{2 * time.Second, rootfs.Progress{Phase: rootfs.PhaseWriting, Layer: 1, Layers: 2, LayerName: "b", BytesRead: 2048, Entries: 5}},
+		{10 * time.Millisecond, rootfs.Progress{Phase: rootfs.PhaseDone, Layer: 1, Layers: 2, LayerName: "b", BytesRead: 2048, Entries: 5}},
+	}
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{
+			mode: _progressTTY,
+			want: "\r\033[Kindexing layer 1/2: 0 entries written, 0 B read" +
+				"\r\033[Kindexing layer 2/2: 0 entries written, 20 B read" +
+				"\r\033[Kwriting layer 1/2: 0 entries written, 30 B read" +
+				"\r\033[Kwriting layer 2/2: 5 entries written, 2.0 KiB read" +
+				"\r\033[Kdone: 5 entries written, 2.0 KiB read\n",
+		},
+		{
+			mode: _progressJSON,
+			want: `{"phase":"indexing","layer":0,"layers":2,"layer_name":"a","bytes_read":0,"entries":0}
+{"phase":"writing","layer":0,"layers":2,"layer_name":"a","bytes_read":30,"entries":0}
+{"phase":"writing","layer":1,"layers":2,"layer_name":"b","bytes_read":2048,"entries":5}
+{"phase":"done","layer":1,"layers":2,"layer_name":"b","bytes_read":2048,"entries":5}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var buf bytes.Buffer
+			p, err := newProgressPrinter(tt.mode, &buf, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			now := time.Unix(0, 0)
+			p.now = func() time.Time { return now }
+			for _, e := range events {
+				now = now.Add(e.after)
+				p.report(e.p)
+			}
+			if got := buf.String(); tt.want != got {
+				t.Errorf("want != got:\n%q\n%q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProgressPrinterFinish(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := newProgressPrinter(_progressTTY, &buf, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.report(rootfs.Progress{Phase: rootfs.PhaseWriting, Layer: 0, Layers: 1})
+	p.finish()
+	p.finish()
+	want := "\r\033[Kwriting layer 1/1: 0 entries written, 0 B read\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+
+	// a finished line is not ended again.
+	buf.Reset()
+	p.report(rootfs.Progress{Phase: rootfs.PhaseDone, Layers: 1})
+	p.finish()
+	if want := "\r\033[Kdone: 0 entries written, 0 B read\n"; buf.String() != want {
+		t.Errorf("want != got: %q != %q", want, buf.String())
+	}
+}
+
+func TestNewProgressPrinter(t *testing.T) {
+	tests := []struct {
+		mode    string
+		isTTY   bool
+		wantNil bool
+		wantErr bool
+	}{
+		{mode: _progressAuto, isTTY: true},
+		{mode: _progressAuto, isTTY: false, wantNil: true},
+		{mode: _progressTTY, isTTY: false},
+		{mode: _progressNone, isTTY: true, wantNil: true},
+		{mode: "bogus", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := newProgressPrinter(tt.mode, &bytes.Buffer{}, tt.isTTY)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error: %v", tt.mode, err)
+		}
+		if !tt.wantErr && (p == nil) != tt.wantNil {
+			t.Errorf("%s (tty=%v): want nil=%v, got %v", tt.mode, tt.isTTY, tt.wantNil, p)
+		}
+	}
+}
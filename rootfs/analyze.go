@@ -0,0 +1,136 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"sort"
+)
+
+const (
+	// WasteOverwritten is the WastedFile.Reason of a file that is replaced
+	// by a file of the same name in a later layer.
+	WasteOverwritten = "overwritten"
+	// WasteDeleted is the WastedFile.Reason of a file that is removed by a
+	// whiteout or an opaque directory.
+	WasteDeleted = "deleted"
+)
+
+type (
+	// LayerWaste is how much a layer contributes to the flattened image,
+	// and how much of it does not make it there. Sizes are the sizes of
+	// the regular files in bytes, sparse files at their full size; whiteout
+	// markers are not counted.
+	LayerWaste struct {
+		// Layer is the index of the layer in the manifest, also with
+		// WithLayers.
+		Layer  int    `json:"layer"`
+		Name   string `json:"name"`
+		Digest string `json:"digest,omitempty"`
+		// Entries is the number of entries in the layer.
+		Entries int `json:"entries"`
+		// Size is the size of all regular files, and Kept of those that are
+		// in the flattened image.
+		Size int64 `json:"size"`
+		Kept int64 `json:"kept"`
+		// Overwritten is the size of the files replaced in later layers,
+		// and Deleted of the files removed by whiteouts or opaque
+		// directories.
+		Overwritten int64 `json:"overwritten"`
+		Deleted     int64 `json:"deleted"`
+	}
+
+	// WastedFile is a regular file of a layer that is not in the flattened
+	// image.
+	WastedFile struct {
+		Name string `json:"name"`
+		// Layer is the index of the layer in the manifest, also with
+		// WithLayers.
+		Layer int   `json:"layer"`
+		Size  int64 `json:"size"`
+		// Reason is WasteOverwritten or WasteDeleted.
+		Reason string `json:"reason"`
+	}
+
+	// Analysis is what Analyze returns.
+	Analysis struct {
+		// Layers are the selected layers, the lowest first.
+		Layers []LayerWaste `json:"layers"`
+		// Wasted are the wasted files, the largest first.
+		Wasted []WastedFile `json:"wasted"`
+	}
+)
+
+// WastedSize returns the total size of the files that are not in the flattened
+// image.
+func (a *Analysis) WastedSize() int64 {
+	var n int64
+	for _, l := range a.Layers {
+		n += l.Overwritten + l.Deleted
+	}
+	return n
+}
+
+// Analyze reads every layer of the image to tell how much each of them
+// contributes to the flattened image, and which files are in the layers but
+// not in the flattened image, because they are overwritten or deleted in a
+// later layer.
+//
+// Only WithWarnings, WithStrict and WithLayers apply.
+func Analyze(rd io.ReadSeeker, opts ...Option) (*Analysis, error) {
+	return AnalyzeContext(context.Background(), rd, opts...)
+}
+
+// AnalyzeContext is like Analyze, but stops when ctx is done, like
+// FlattenContext.
+func AnalyzeContext(ctx context.Context, rd io.ReadSeeker, opts ...Option) (*Analysis, error) {
+	img, err := openImage(ctx, rd, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	a := &Analysis{Layers: make([]LayerWaste, len(img.layers))}
+	for i, no := range img.layers {
+		a.Layers[i] = LayerWaste{
+			Layer:  img.first + i,
+			Name:   no.name,
+			Digest: layerDigest(no.name),
+		}
+	}
+	err = img.scan(ctx, func(i int, hdr *tar.Header, _ *entryReader, v verdict) error {
+		lw := &a.Layers[i]
+		lw.Entries++
+		if _, _, ok := whiteout(hdr); ok {
+			return nil
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeGNUSparse {
+			return nil
+		}
+		lw.Size += hdr.Size
+		var reason string
+		switch v {
+		case verdictKept:
+			lw.Kept += hdr.Size
+			return nil
+		case verdictOverwritten:
+			lw.Overwritten += hdr.Size
+			reason = WasteOverwritten
+		default:
+			lw.Deleted += hdr.Size
+			reason = WasteDeleted
+		}
+		a.Wasted = append(a.Wasted, WastedFile{
+			Name:   hdr.Name,
+			Layer:  img.first + i,
+			Size:   hdr.Size,
+			Reason: reason,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(a.Wasted, func(i, j int) bool {
+		return a.Wasted[i].Size > a.Wasted[j].Size
+	})
+	return a, nil
+}
@@ -0,0 +1,94 @@
+package rootfs
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/sha256/aaa", Contents: tarball{
+			dir{Name: "etc"},
+			file{Name: "etc/passwd", Contents: bytes.NewBufferString("root")},
+			file{Name: "etc/shadow", Contents: bytes.NewBufferString("root:*")},
+			dir{Name: "var/cache"},
+			file{Name: "var/cache/apk", Contents: bytes.NewBufferString(strings.Repeat("x", 100))},
+		}.Buffer()},
+		file{Name: "blobs/sha256/bbb", Contents: tarball{
+			file{Name: "etc/passwd", Contents: bytes.NewBufferString("root:x:0:0")},
+			hardlink{Name: "etc/.wh.shadow"},
+			hardlink{Name: "var/cache/.wh..wh..opq"},
+		}.Buffer()},
+		manifest{"blobs/sha256/aaa", "blobs/sha256/bbb"},
+	}
+
+	got, err := Analyze(bytes.NewReader(image.Buffer().Bytes()))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	want := &Analysis{
+		Layers: []LayerWaste{
+			{
+				Name:        "blobs/sha256/aaa",
+				Digest:      "sha256:aaa",
+				Entries:     5,
+				Size:        110,
+				Overwritten: 4,
+				Deleted:     106,
+			},
+			{
+				Layer:   1,
+				Name:    "blobs/sha256/bbb",
+				Digest:  "sha256:bbb",
+				Entries: 3,
+				Size:    10,
+				Kept:    10,
+			},
+		},
+		Wasted: []WastedFile{
+			{Name: "var/cache/apk", Layer: 0, Size: 100, Reason: WasteDeleted},
+			{Name: "etc/shadow", Layer: 0, Size: 6, Reason: WasteDeleted},
+			{Name: "etc/passwd", Layer: 0, Size: 4, Reason: WasteOverwritten},
+		},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %+v != %+v", want, got)
+	}
+	if n := got.WastedSize(); n != 110 {
+		t.Errorf("expected 110 wasted bytes, got %d", n)
+	}
+}
+
+func TestAnalyzeSparse(t *testing.T) {
+	contents := make([]byte, 4*4096)
+	var layer bytes.Buffer
+	layer.Write(oldGNUSparse("var/db", 0600, 0, contents, []sparseEntry{
+		{offset: 4096, length: 4096},
+		{offset: int64(len(contents)), length: 0},
+	}))
+	layer.Write(make([]byte, 2*_blockSize))
+
+	image := tarball{
+		file{Name: "blobs/sha256/aaa", Contents: &layer},
+		file{Name: "blobs/sha256/bbb", Contents: tarball{
+			hardlink{Name: "var/.wh.db"},
+		}.Buffer()},
+		manifest{"blobs/sha256/aaa", "blobs/sha256/bbb"},
+	}
+
+	got, err := Analyze(bytes.NewReader(image.Buffer().Bytes()))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if lw := got.Layers[0]; lw.Size != 4*4096 || lw.Deleted != 4*4096 {
+		t.Errorf("expected the sparse file to be counted, got %+v", lw)
+	}
+	want := []WastedFile{
+		{Name: "var/db", Layer: 0, Size: 4 * 4096, Reason: WasteDeleted},
+	}
+	if !reflect.DeepEqual(want, got.Wasted) {
+		t.Errorf("want != got: %+v != %+v", want, got.Wasted)
+	}
+}
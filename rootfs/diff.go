@@ -0,0 +1,358 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Kinds of a Change.
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// Fields of a modified Change.
+const (
+	FieldType     = "type"
+	FieldMode     = "mode"
+	FieldOwner    = "owner"
+	FieldLinkname = "linkname"
+	FieldDevice   = "device"
+	FieldContent  = "content"
+)
+
+// Change is a path that differs between the flattened images, as returned
+// by Diff.
+type Change struct {
+	Path string `json:"path"`
+	// Kind is one of the Change constants.
+	Kind string `json:"kind"`
+	// Fields are what is modified, Field constants in the order they are
+	// declared.
+	Fields []string `json:"fields,omitempty"`
+	// Old and New are the entries of the path in the first and in the
+	// second image, nil if the path is not there.
+	Old *tar.Header `json:"-"`
+	New *tar.Header `json:"-"`
+}
+
+// diffEntry is an entry of a flattened image, as compared by Diff.
+type diffEntry struct {
+	hdr *tar.Header
+	// layer is the layer the entry comes from, and ordinal the number of
+	// the entry in it. Entries from the same place of the same layer blob
+	// are the same.
+	layer   layerID
+	ordinal int
+	sum     [sha256.Size]byte
+}
+
+// Diff compares the flattened images a and b, and returns the paths that are
+// added, removed or modified in b, sorted by path. Paths are compared after
+// cleaning, so "./etc" and "etc" are the same. A path is modified if its
+// type, permission bits, owner, link target, device numbers or contents
+// differ; modification times are not compared. Sparse files are regular
+// files, like in Flatten.
+//
+// Every layer is read once. The layers that both images start with, by
+// digest or by diff_id, are read only from a, after the other layers, and
+// only the files that the other layers replace are hashed in them. Entries
+// that come from the same place of the same layer in both images are not
+// compared.
+//
+// Only WithWarnings, WithStrict and WithLayers apply.
+func Diff(a, b io.ReadSeeker, opts ...Option) ([]Change, error) {
+	return DiffContext(context.Background(), a, b, opts...)
+}
+
+// DiffContext is like Diff, but stops when ctx is done, like FlattenContext.
+func DiffContext(ctx context.Context, a, b io.ReadSeeker, opts ...Option) ([]Change, error) {
+	o := newOptions(opts)
+	imgA, err := openDiffImage(ctx, a, o)
+	if err != nil {
+		return nil, err
+	}
+	imgB, err := openDiffImage(ctx, b, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// shared is the number of layers both images start with. touched are
+	// the names of the entries in the other layers, the only ones that can
+	// differ.
+	shared := 0
+	for shared < len(imgA.ids) && shared < len(imgB.ids) &&
+		imgA.ids[shared].same(imgB.ids[shared]) {
+		shared++
+	}
+	touched := map[string]struct{}{}
+	layersA, err := imgA.readLayers(ctx, o, shared, len(imgA.layers), nil, touched)
+	if err != nil {
+		return nil, err
+	}
+	layersB, err := imgB.readLayers(ctx, o, shared, len(imgB.layers), nil, touched)
+	if err != nil {
+		return nil, err
+	}
+	base, err := imgA.readLayers(ctx, o, 0, shared, touched, nil)
+	if err != nil {
+		return nil, err
+	}
+	entriesA := mergeLayers(imgA.first > 0, base, layersA)
+	entriesB := mergeLayers(imgB.first > 0, base, layersB)
+
+	var ret []Change
+	for name, ea := range entriesA {
+		eb, ok := entriesB[name]
+		if !ok {
+			ret = append(ret, Change{Path: name, Kind: ChangeRemoved, Old: ea.hdr})
+			continue
+		}
+		if ea == eb || ea.ordinal == eb.ordinal && ea.layer.same(eb.layer) {
+			continue
+		}
+		fields := diffHeaders(ea.hdr, eb.hdr)
+		if isRegular(ea.hdr) && isRegular(eb.hdr) &&
+			ea.hdr.Size == eb.hdr.Size && ea.sum != eb.sum {
+			fields = append(fields, FieldContent)
+		}
+		if len(fields) > 0 {
+			ret = append(ret, Change{
+				Path:   name,
+				Kind:   ChangeModified,
+				Fields: fields,
+				Old:    ea.hdr,
+				New:    eb.hdr,
+			})
+		}
+	}
+	for name, eb := range entriesB {
+		if _, ok := entriesA[name]; !ok {
+			ret = append(ret, Change{Path: name, Kind: ChangeAdded, New: eb.hdr})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret, nil
+}
+
+// layerID identifies a layer blob by its digest and by its diff_id, either
+// of which may be unknown.
+type layerID struct {
+	digest string
+	diffID string
+}
+
+// same returns whether id and other are known to be the same layer.
+func (id layerID) same(other layerID) bool {
+	return id.digest != "" && id.digest == other.digest ||
+		id.diffID != "" && id.diffID == other.diffID
+}
+
+// diffImage is an image as read by Diff.
+type diffImage struct {
+	rd     io.ReadSeeker
+	layers []nameOffset
+	ids    []layerID
+	// first is the index of the lowest of layers in the image.
+	first int
+}
+
+// openDiffImage reads the manifest and the configuration of the image.
+func openDiffImage(ctx context.Context, rd io.ReadSeeker, o *options) (*diffImage, error) {
+	arc, err := readArchive(ctx, rd, o)
+	if err != nil {
+		return nil, err
+	}
+	first, last, err := arc.layerRange(rd, o)
+	if err != nil {
+		return nil, err
+	}
+	config, err := arc.readConfig(rd)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]layerID, len(arc.layers))
+	for i, no := range arc.layers {
+		ids[i].digest = layerDigest(no.name)
+		if config != nil && len(config.RootFS.DiffIDs) == len(arc.layers) {
+			ids[i].diffID = config.RootFS.DiffIDs[i]
+		}
+	}
+	return &diffImage{
+		rd:     rd,
+		layers: arc.layers[first : last+1],
+		ids:    ids[first : last+1],
+		first:  first,
+	}, nil
+}
+
+// readLayers reads the entries of the layers of img from index from up to
+// index to. The contents of the regular files are hashed if hash is nil or
+// has their cleaned names. The cleaned names of the entries are added to
+// names, if it is not nil.
+func (img *diffImage) readLayers(
+	ctx context.Context,
+	o *options,
+	from, to int,
+	hash, names map[string]struct{},
+) ([][]*diffEntry, error) {
+	var ret [][]*diffEntry
+	for i := from; i < to; i++ {
+		no := img.layers[i]
+		l, err := openLayer(img.rd, no)
+		if err != nil {
+			return nil, err
+		}
+		var entries []*diffEntry
+		var nentries int
+		seen := map[string]struct{}{}
+		for {
+			hdr, err := l.next()
+			if err == io.EOF {
+				if nentries == 0 {
+					if err := o.warn(WarningEmptyLayer, no.name, "", "empty layer"); err != nil {
+						return nil, err
+					}
+				}
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", no.name, hdr.Name, err)
+			}
+			nentries++
+			if err := warnEntry(o, no.name, hdr, seen); err != nil {
+				return nil, err
+			}
+
+			name := cleanName(hdr.Name)
+			e := &diffEntry{hdr: hdr, layer: img.ids[i], ordinal: l.n}
+			if _, ok := hash[name]; (hash == nil || ok) && isRegular(hdr) {
+				h := sha256.New()
+				if _, err := io.Copy(h, &entryReader{ctx: ctx, l: l, name: hdr.Name}); err != nil {
+					return nil, err
+				}
+				h.Sum(e.sum[:0])
+			}
+			if names != nil {
+				names[name] = struct{}{}
+			}
+			entries = append(entries, e)
+		}
+		if err := l.close(); err != nil {
+			return nil, err
+		}
+		ret = append(ret, entries)
+	}
+	return ret, nil
+}
+
+// mergeLayers returns the entries of the flattened layers by cleaned path,
+// the last one winning. The layers are judged by an index of all of them,
+// like in Flatten; dropLowest is as for newIndex.
+func mergeLayers(dropLowest bool, layers ...[][]*diffEntry) map[string]*diffEntry {
+	idx := newIndex(dropLowest)
+	var all [][]*diffEntry
+	for _, ls := range layers {
+		all = append(all, ls...)
+	}
+	for i, entries := range all {
+		for _, e := range entries {
+			idx.add(i, e.hdr)
+		}
+	}
+	idx.seal(len(all))
+
+	ret := map[string]*diffEntry{}
+	for i, entries := range all {
+		for _, e := range entries {
+			if idx.keep(i, e.hdr) {
+				ret[cleanName(e.hdr.Name)] = e
+			}
+		}
+	}
+	return ret
+}
+
+// diffEntries returns the entries of the flattened image by cleaned path,
+// the last one winning. The contents of the regular files in hash are
+// hashed.
+func (img *image) diffEntries(ctx context.Context, hash map[string]struct{}) (map[string]*diffEntry, error) {
+	ret := map[string]*diffEntry{}
+	err := img.walk(ctx, func(i int, hdr *tar.Header, r *entryReader) error {
+		name := cleanName(hdr.Name)
+		e := &diffEntry{
+			hdr:     hdr,
+			layer:   layerID{digest: layerDigest(img.layers[i].name)},
+			ordinal: r.l.n,
+		}
+		if _, ok := hash[name]; ok && isRegular(hdr) {
+			h := sha256.New()
+			if _, err := io.Copy(h, r); err != nil {
+				return err
+			}
+			h.Sum(e.sum[:0])
+		}
+		ret[name] = e
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// diffHeaders returns the fields that differ between a and b. Only the
+// permission bits of the modes are compared, since Flatten does not write
+// the others, and the device numbers only of devices. The contents of
+// regular files differ if the sizes do; otherwise they are not compared.
+func diffHeaders(a, b *tar.Header) []string {
+	var ret []string
+	if typeflag(a) != typeflag(b) {
+		ret = append(ret, FieldType)
+	}
+	if a.Mode&0777 != b.Mode&0777 {
+		ret = append(ret, FieldMode)
+	}
+	if a.Uid != b.Uid || a.Gid != b.Gid {
+		ret = append(ret, FieldOwner)
+	}
+	if a.Linkname != b.Linkname {
+		ret = append(ret, FieldLinkname)
+	}
+	if isDevice(a) && typeflag(a) == typeflag(b) &&
+		(a.Devmajor != b.Devmajor || a.Devminor != b.Devminor) {
+		ret = append(ret, FieldDevice)
+	}
+	if isRegular(a) && isRegular(b) && a.Size != b.Size {
+		ret = append(ret, FieldContent)
+	}
+	return ret
+}
+
+// typeflag returns the type of hdr as Flatten writes it: old GNU sparse
+// files are regular files.
+func typeflag(hdr *tar.Header) byte {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return tar.TypeReg
+	}
+	return hdr.Typeflag
+}
+
+// isRegular returns whether hdr is a regular file, sparse or not.
+func isRegular(hdr *tar.Header) bool {
+	return typeflag(hdr) == tar.TypeReg
+}
+
+// isDevice returns whether hdr is a character or a block device.
+func isDevice(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock
+}
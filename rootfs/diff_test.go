@@ -0,0 +1,186 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	base := tarball{
+		dir{Name: "etc"},
+		file{Name: "etc/group", Contents: bytes.NewBufferString("root:x:0:")},
+		file{Name: "etc/hosts", Contents: bytes.NewBufferString("127.0.0.1")},
+		file{Name: "etc/motd", Contents: bytes.NewBufferString("hi")},
+		file{Name: "etc/passwd", Contents: bytes.NewBufferString("root")},
+		symlink{Name: "bin/sh", Target: "busybox"},
+	}
+
+	// sparse has the same contents as var/log, and different from var/db.
+	contents := make([]byte, 3*4096)
+	copy(contents[4096:], "data")
+	var sparse bytes.Buffer
+	for _, name := range []string{"var/db", "var/log"} {
+		sparse.Write(oldGNUSparse(name, 0644, 0, contents, []sparseEntry{
+			{offset: 4096, length: 4096},
+			{offset: int64(len(contents)), length: 0},
+		}))
+	}
+	sparse.Write(make([]byte, 2*_blockSize))
+	changed := bytes.Clone(contents)
+	changed[4096] = 'D'
+
+	tests := []struct {
+		name string
+		a, b tarball
+		want []string
+	}{
+		{
+			name: "changes",
+			a: tarball{
+				file{Name: "blobs/sha256/aaa", Contents: base.Buffer()},
+				file{Name: "blobs/sha256/bbb", Contents: tarball{
+					file{Name: "etc/old"},
+				}.Buffer()},
+				manifest{"blobs/sha256/aaa", "blobs/sha256/bbb"},
+			},
+			b: tarball{
+				file{Name: "blobs/sha256/aaa", Contents: base.Buffer()},
+				file{Name: "blobs/sha256/ccc", Contents: tarball{
+					file{Name: "etc/group", Contents: bytes.NewBufferString("root:x:0:root")},
+					file{Name: "etc/hosts", Contents: bytes.NewBufferString("127.0.0.2")},
+					file{Name: "etc/motd", Contents: bytes.NewBufferString("hi"), UID: 1},
+					file{Name: "./etc/new"},
+					symlink{Name: "bin/sh", Target: "bash"},
+				}.Buffer()},
+				manifest{"blobs/sha256/aaa", "blobs/sha256/ccc"},
+			},
+			want: []string{
+				"bin/sh modified [linkname]",
+				"etc/group modified [content]",
+				"etc/hosts modified [content]",
+				"etc/motd modified [owner]",
+				"etc/new added []",
+				"etc/old removed []",
+			},
+		},
+		{
+			// a layer blob is trusted to be what its digest says
+			name: "same digest",
+			a: tarball{
+				file{Name: "blobs/sha256/aaa", Contents: base.Buffer()},
+				manifest{"blobs/sha256/aaa"},
+			},
+			b: tarball{
+				file{Name: "blobs/sha256/aaa", Contents: tarball{
+					dir{Name: "etc"},
+					file{Name: "etc/group", Contents: bytes.NewBufferString("root:x:0:")},
+					file{Name: "etc/hosts", Contents: bytes.NewBufferString("127.0.0.2")},
+					file{Name: "etc/motd", Contents: bytes.NewBufferString("hi")},
+					file{Name: "etc/passwd", Contents: bytes.NewBufferString("root")},
+					symlink{Name: "bin/sh", Target: "busybox"},
+				}.Buffer()},
+				manifest{"blobs/sha256/aaa"},
+			},
+		},
+		{
+			// shared layers are read only from the first image
+			name: "same diff_id",
+			a: tarball{
+				file{Name: "blobs/layer0/layer", Contents: base.Buffer()},
+				file{Name: "blobs/layer1/layer", Contents: tarball{
+					file{Name: "etc/old"},
+				}.Buffer()},
+				file{Name: "blobs/config", Contents: bytes.NewBufferString(
+					`{"rootfs":{"type":"layers","diff_ids":["sha256:000","sha256:111"]}}`)},
+				file{Name: "manifest.json", Contents: bytes.NewBufferString(`[{
+					"Config": "blobs/config",
+					"Layers": ["blobs/layer0/layer", "blobs/layer1/layer"]
+				}]`)},
+			},
+			b: tarball{
+				file{Name: "blobs/layer0/layer", Contents: bytes.NewBufferString("not a tarball")},
+				file{Name: "blobs/layer1/layer", Contents: tarball{
+					file{Name: "etc/hosts", Contents: bytes.NewBufferString("127.0.0.2")},
+					file{Name: "etc/motd", Contents: bytes.NewBufferString("hi")},
+					hardlink{Name: "etc/.wh.passwd"},
+				}.Buffer()},
+				file{Name: "blobs/config", Contents: bytes.NewBufferString(
+					`{"rootfs":{"type":"layers","diff_ids":["sha256:000","sha256:222"]}}`)},
+				file{Name: "manifest.json", Contents: bytes.NewBufferString(`[{
+					"Config": "blobs/config",
+					"Layers": ["blobs/layer0/layer", "blobs/layer1/layer"]
+				}]`)},
+			},
+			want: []string{
+				"etc/hosts modified [content]",
+				"etc/old removed []",
+				"etc/passwd removed []",
+			},
+		},
+		{
+			name: "different digest",
+			a: tarball{
+				file{Name: "blobs/sha256/aaa", Contents: base.Buffer()},
+				manifest{"blobs/sha256/aaa"},
+			},
+			b: tarball{
+				file{Name: "blobs/sha256/bbb", Contents: base.Buffer()},
+				manifest{"blobs/sha256/bbb"},
+			},
+		},
+		{
+			name: "sparse",
+			a: tarball{
+				file{Name: "blobs/sha256/aaa", Contents: &sparse},
+				manifest{"blobs/sha256/aaa"},
+			},
+			b: tarball{
+				file{Name: "blobs/sha256/bbb", Contents: tarball{
+					file{Name: "var/db", Contents: bytes.NewBuffer(changed)},
+					file{Name: "var/log", Contents: bytes.NewBuffer(contents)},
+				}.Buffer()},
+				manifest{"blobs/sha256/bbb"},
+			},
+			want: []string{"var/db modified [content]"},
+		},
+		{
+			name: "devices",
+			a: tarball{
+				file{Name: "blobs/sha256/aaa", Contents: tarball{
+					rawHeader{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+					rawHeader{Name: "dev/zero", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 5},
+				}.Buffer()},
+				manifest{"blobs/sha256/aaa"},
+			},
+			b: tarball{
+				file{Name: "blobs/sha256/bbb", Contents: tarball{
+					rawHeader{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+					rawHeader{Name: "dev/zero", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 7},
+				}.Buffer()},
+				manifest{"blobs/sha256/bbb"},
+			},
+			want: []string{"dev/zero modified [device]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := Diff(
+				bytes.NewReader(tt.a.Buffer().Bytes()),
+				bytes.NewReader(tt.b.Buffer().Bytes()),
+			)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			var got []string
+			for _, c := range changes {
+				got = append(got, fmt.Sprintf("%s %s %v", c.Path, c.Kind, c.Fields))
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want != got: %q != %q", tt.want, got)
+			}
+		})
+	}
+}
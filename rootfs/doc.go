@@ -66,13 +66,25 @@
 // Note: these may be regular files in practice. So this implementation will
 // match either.
 //
+// == Empty layers ==
+//
+// Some tools emit "no changes" layers as zero-length blobs or as tarballs
+// that contain only the end-of-archive marker. Both are treated as empty
+// layers; use WithWarnings to be notified about them.
+//
 // == Tar format ==
 //
 // Since we do care about long filenames and large file sizes (>8GB), we are
 // using "classic" GNU Tar. However, at least NetBSD pax is known to have
 // problems reading it[2].
 //
+// Files that were sparse in the layers are written in the PAX 1.0 sparse
+// format[3], which GNU tar and libarchive extract to sparse files. Holes are
+// found by looking for blocks of zeroes.
+//
 // [1]: https://manpages.debian.org/unstable/aufs-tools/mount.aufs.8.en.html
 //
 // [2]: https://mgorny.pl/articles/portability-of-tar-features.html
+//
+// [3]: https://www.gnu.org/software/tar/manual/html_node/Sparse-Formats.html
 package rootfs
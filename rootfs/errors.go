@@ -0,0 +1,81 @@
+package rootfs
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMissingManifest means that the image has no manifest.json, or
+	// that it lists no images.
+	ErrMissingManifest = errors.New("empty or missing manifest")
+
+	// ErrMissingLayer matches every *MissingLayerError.
+	ErrMissingLayer = errors.New("missing layer")
+
+	// ErrCorruptLayer matches every *CorruptLayerError.
+	ErrCorruptLayer = errors.New("corrupt layer")
+
+	// ErrUnsupportedCompression matches every *UnsupportedCompressionError.
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+)
+
+// MissingLayerError means that a layer is listed in the manifest, but is not
+// in the image.
+type MissingLayerError struct {
+	// Layer is the layer name as listed in manifest.json.
+	Layer string
+}
+
+func (e *MissingLayerError) Error() string {
+	return fmt.Sprintf("%s defined in manifest, missing in tarball", e.Layer)
+}
+
+// Is makes errors.Is(err, ErrMissingLayer) work.
+func (e *MissingLayerError) Is(target error) bool {
+	return target == ErrMissingLayer
+}
+
+// CorruptLayerError means that a layer could not be read.
+type CorruptLayerError struct {
+	// Op is the operation that failed: "open", "decode" or "read".
+	Op string
+	// Layer is the layer name as listed in manifest.json.
+	Layer string
+	// Offset is how far into the layer blob the error was found. Since
+	// the blob is read in chunks, it is approximate.
+	Offset int64
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *CorruptLayerError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Layer, e.Err)
+}
+
+func (e *CorruptLayerError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrCorruptLayer) work.
+func (e *CorruptLayerError) Is(target error) bool {
+	return target == ErrCorruptLayer
+}
+
+// UnsupportedCompressionError means that a layer is compressed with
+// something else than gzip.
+type UnsupportedCompressionError struct {
+	// Layer is the layer name as listed in manifest.json.
+	Layer string
+	// Compression is the detected compression, e.g. "zstd".
+	Compression string
+}
+
+func (e *UnsupportedCompressionError) Error() string {
+	return fmt.Sprintf("open %s: unsupported compression: %s", e.Layer, e.Compression)
+}
+
+// Is makes errors.Is(err, ErrUnsupportedCompression) work.
+func (e *UnsupportedCompressionError) Is(target error) bool {
+	return target == ErrUnsupportedCompression
+}
@@ -0,0 +1,122 @@
+package rootfs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	bigFile := tarball{
+		file{Name: "big", Contents: bytes.NewBuffer(bytes.Repeat([]byte("x"), 1<<16))},
+	}.Gzip().Bytes()
+
+	tests := []struct {
+		name    string
+		image   tarball
+		wantIs  error
+		wantErr string
+		check   func(*testing.T, error)
+	}{
+		{
+			name:    "missing manifest",
+			image:   tarball{},
+			wantIs:  ErrMissingManifest,
+			wantErr: "empty or missing manifest",
+		},
+		{
+			name:    "missing layer",
+			image:   tarball{manifest{"blobs/layer0/layer"}},
+			wantIs:  ErrMissingLayer,
+			wantErr: "blobs/layer0/layer defined in manifest, missing in tarball",
+			check: func(t *testing.T, err error) {
+				var mlErr *MissingLayerError
+				if !errors.As(err, &mlErr) || mlErr.Layer != "blobs/layer0/layer" {
+					t.Errorf("expected a *MissingLayerError, got %#v", err)
+				}
+			},
+		},
+		{
+			name: "corrupt layer",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: bytes.NewBuffer(bytes.Repeat([]byte("x"), 1024))},
+				manifest{"blobs/layer0/layer"},
+			},
+			wantIs:  ErrCorruptLayer,
+			wantErr: "decode blobs/layer0/layer: archive/tar: invalid tar header",
+			check: func(t *testing.T, err error) {
+				var clErr *CorruptLayerError
+				if !errors.As(err, &clErr) {
+					t.Fatalf("expected a *CorruptLayerError, got %#v", err)
+				}
+				if clErr.Op != "decode" || clErr.Layer != "blobs/layer0/layer" || clErr.Offset == 0 {
+					t.Errorf("unexpected error: %#v", clErr)
+				}
+			},
+		},
+		{
+			name: "truncated gzip layer",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: bytes.NewBuffer(bigFile[:len(bigFile)/2])},
+				manifest{"blobs/layer0/layer"},
+			},
+			wantIs:  ErrCorruptLayer,
+			wantErr: "decode blobs/layer0/layer: unexpected EOF",
+		},
+		{
+			name: "zstd layer",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: bytes.NewBuffer(
+					[]byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0},
+				)},
+				manifest{"blobs/layer0/layer"},
+			},
+			wantIs:  ErrUnsupportedCompression,
+			wantErr: "open blobs/layer0/layer: unsupported compression: zstd",
+			check: func(t *testing.T, err error) {
+				var ucErr *UnsupportedCompressionError
+				if !errors.As(err, &ucErr) || ucErr.Compression != "zstd" {
+					t.Errorf("expected an *UnsupportedCompressionError, got %#v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := bytes.NewReader(tt.image.Buffer().Bytes())
+			err := Flatten(in, &bytes.Buffer{})
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !errors.Is(err, tt.wantIs) {
+				t.Errorf("expected errors.Is(%v, %v)", err, tt.wantIs)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("want != got: %s != %s", tt.wantErr, err.Error())
+			}
+			if tt.check != nil {
+				tt.check(t, err)
+			}
+		})
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		head []byte
+		want string
+	}{
+		{head: []byte{0x1f, 0x8b, 0x08}, want: "gzip"},
+		{head: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, want: "zstd"},
+		{head: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, want: "xz"},
+		{head: []byte("BZh91AY&SY"), want: "bzip2"},
+		{head: []byte("BZh9/etc/p"), want: ""},
+		{head: []byte("etc/passwd"), want: ""},
+	}
+	for _, tt := range tests {
+		if got := detectCompression(tt.head); tt.want != got {
+			t.Errorf("%q: want != got: %q != %q", tt.head, tt.want, got)
+		}
+	}
+}
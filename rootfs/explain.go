@@ -0,0 +1,182 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+)
+
+// Actions of a TraceEvent.
+const (
+	// ActionAdd is the first entry of the path.
+	ActionAdd = "add"
+	// ActionReplace is an entry of the path after an earlier one.
+	ActionReplace = "replace"
+	// ActionWhiteout is a .wh. file that hides the path.
+	ActionWhiteout = "whiteout"
+	// ActionOpaque is a .wh..wh..opq file in the path or a directory above
+	// it.
+	ActionOpaque = "opaque"
+)
+
+// Fates of the entries in a TraceEvent.
+const (
+	// FateKept is an entry that is in the flattened image.
+	FateKept = "kept"
+	// FateOverwritten is an entry that is replaced by an entry in a later
+	// layer.
+	FateOverwritten = "overwritten"
+	// FateWhiteout is an entry that is hidden by a .wh. file in the same
+	// or a later layer.
+	FateWhiteout = "whiteout"
+	// FateOpaque is an entry in a directory that is made opaque in a later
+	// layer.
+	FateOpaque = "opaque"
+)
+
+type (
+	// TraceEvent is an entry of a layer that bears on a path.
+	TraceEvent struct {
+		// Layer is the index of the layer in the manifest, also with
+		// WithLayers, and LayerName its name as listed there.
+		Layer     int         `json:"layer"`
+		LayerName string      `json:"layer_name"`
+		Header    *tar.Header `json:"-"`
+		// Name is the name of the entry in the layer.
+		Name string `json:"name"`
+		// Action is one of the Action constants.
+		Action string `json:"action"`
+		// Fate is what Flatten does with an ActionAdd or ActionReplace
+		// entry, one of the Fate constants. ByLayer and By are the layer
+		// and the name of the entry that overwrites or hides it.
+		Fate    string `json:"fate,omitempty"`
+		ByLayer int    `json:"by_layer,omitempty"`
+		By      string `json:"by,omitempty"`
+	}
+
+	// Trace is the history of a path across the layers of an image, as
+	// returned by Explain.
+	Trace struct {
+		Path   string       `json:"path"`
+		Events []TraceEvent `json:"events"`
+	}
+)
+
+// Kept returns the events of the entries that are in the flattened image.
+// There is at most one, unless the path is a directory or it is spelled
+// differently in the layers, e.g. "./etc" and "etc".
+func (t *Trace) Kept() []TraceEvent {
+	var ret []TraceEvent
+	for _, ev := range t.Events {
+		if ev.Fate == FateKept {
+			ret = append(ret, ev)
+		}
+	}
+	return ret
+}
+
+// Explain traces name through the layers of the image: where it is added or
+// replaced, which .wh. files hide it, which .wh..wh..opq files make the
+// directories above it opaque, and what Flatten does with every entry of it.
+// The fates are the decisions of Flatten itself. Entries match name after
+// cleaning, so "./etc/passwd" in a layer matches "/etc/passwd". Symlinks are
+// not followed.
+//
+// Only WithWarnings, WithStrict and WithLayers apply.
+func Explain(rd io.ReadSeeker, name string, opts ...Option) (*Trace, error) {
+	return ExplainContext(context.Background(), rd, name, opts...)
+}
+
+// ExplainContext is like Explain, but stops when ctx is done, like
+// FlattenContext.
+func ExplainContext(ctx context.Context, rd io.ReadSeeker, name string, opts ...Option) (*Trace, error) {
+	img, err := openImage(ctx, rd, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	name = cleanName(name)
+	t := &Trace{Path: name}
+	// verdicts are the verdicts of the events, by index in t.Events.
+	verdicts := map[int]verdict{}
+	err = img.scan(ctx, func(i int, hdr *tar.Header, _ *entryReader, v verdict) error {
+		ev := TraceEvent{
+			Layer:     img.first + i,
+			LayerName: img.layers[i].name,
+			Header:    hdr,
+			Name:      hdr.Name,
+		}
+		if basedir, fname, ok := whiteout(hdr); ok {
+			switch {
+			case fname == "" && newTree(basedir).HasPrefix(name):
+				ev.Action = ActionOpaque
+			case fname != "" && cleanName(filepath.Join(basedir, fname)) == name:
+				ev.Action = ActionWhiteout
+			default:
+				return nil
+			}
+			t.Events = append(t.Events, ev)
+			return nil
+		}
+		if cleanName(hdr.Name) != name {
+			return nil
+		}
+		ev.Action = ActionAdd
+		for _, prev := range t.Events {
+			if prev.Action == ActionAdd || prev.Action == ActionReplace {
+				ev.Action = ActionReplace
+				break
+			}
+		}
+		verdicts[len(t.Events)] = v
+		t.Events = append(t.Events, ev)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for j, v := range verdicts {
+		ev := &t.Events[j]
+		switch v {
+		case verdictKept:
+			ev.Fate = FateKept
+		case verdictOverwritten:
+			ev.Fate = FateOverwritten
+			ev.ByLayer, ev.By = img.first+img.idx.file2layer[ev.Name], ev.Name
+		case verdictWhiteout:
+			ev.Fate = FateWhiteout
+			ev.ByLayer = img.first + img.idx.wh[ev.Name]
+			for _, e := range t.Events {
+				basedir, fname, _ := whiteout(e.Header)
+				if e.Action == ActionWhiteout && e.Layer == ev.ByLayer &&
+					filepath.Join(basedir, fname) == ev.Name {
+					ev.By = e.Name
+					break
+				}
+			}
+		case verdictOpaque:
+			ev.Fate = FateOpaque
+			// the opaque directory that hides the entry is the lowest
+			// one above its layer.
+			for _, e := range t.Events {
+				basedir, _, _ := whiteout(e.Header)
+				if e.Action == ActionOpaque && e.Layer > ev.Layer &&
+					newTree(basedir).HasPrefix(ev.Name) {
+					ev.ByLayer, ev.By = e.Layer, e.Name
+					break
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
+// cleanName cleans name to a relative path, "." being the root.
+func cleanName(name string) string {
+	if name = path.Clean("/" + name)[1:]; name == "" {
+		return "."
+	}
+	return name
+}
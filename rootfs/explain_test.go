@@ -0,0 +1,75 @@
+package rootfs
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/sha256/aaa", Contents: tarball{
+			dir{Name: "etc"},
+			file{Name: "etc/passwd"},
+			file{Name: "etc/shadow"},
+			file{Name: "var/cache/apk/index"},
+		}.Buffer()},
+		file{Name: "blobs/sha256/bbb", Contents: tarball{
+			file{Name: "etc/passwd"},
+			hardlink{Name: "etc/.wh.shadow"},
+		}.Buffer()},
+		file{Name: "blobs/sha256/ccc", Contents: tarball{
+			hardlink{Name: "var/cache/.wh..wh..opq"},
+			file{Name: "./etc/shadow"},
+		}.Buffer()},
+		manifest{"blobs/sha256/aaa", "blobs/sha256/bbb", "blobs/sha256/ccc"},
+	}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{
+			name: "/etc/passwd",
+			want: []string{
+				"0 etc/passwd add overwritten 1 etc/passwd",
+				"1 etc/passwd replace kept 0 ",
+			},
+		},
+		{
+			name: "etc/shadow",
+			want: []string{
+				"0 etc/shadow add whiteout 1 etc/.wh.shadow",
+				"1 etc/.wh.shadow whiteout  0 ",
+				"2 ./etc/shadow replace kept 0 ",
+			},
+		},
+		{
+			name: "var/cache/apk/index",
+			want: []string{
+				"0 var/cache/apk/index add opaque 2 var/cache/.wh..wh..opq",
+				"2 var/cache/.wh..wh..opq opaque  0 ",
+			},
+		},
+		{
+			name: "etc/group",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := Explain(bytes.NewReader(image.Buffer().Bytes()), tt.name)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			var got []string
+			for _, ev := range trace.Events {
+				got = append(got, fmt.Sprintf("%d %s %s %s %d %s",
+					ev.Layer, ev.Name, ev.Action, ev.Fate, ev.ByLayer, ev.By))
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want != got: %q != %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// _globstar matches any number of path elements in a pattern.
+const _globstar = "**"
+
+// filter selects the paths of the flattened image by glob patterns. See
+// WithInclude and WithExclude.
+type filter struct {
+	include, exclude [][]string
+}
+
+// newFilter compiles the include and exclude patterns.
+func newFilter(include, exclude []string) (*filter, error) {
+	f := &filter{}
+	for _, p := range include {
+		elems, err := compileGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, elems)
+	}
+	for _, p := range exclude {
+		elems, err := compileGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, elems)
+	}
+	return f, nil
+}
+
+// compileGlob splits pattern to path elements, and checks that they are
+// valid path.Match patterns.
+func compileGlob(pattern string) ([]string, error) {
+	cleaned := cleanName(pattern)
+	if cleaned == "." {
+		return []string{_globstar}, nil
+	}
+	elems := strings.Split(cleaned, "/")
+	for _, elem := range elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+	return elems, nil
+}
+
+// keep returns whether the entry hdr passes the filter. Hardlinks are only
+// kept with their targets.
+func (f *filter) keep(hdr *tar.Header) bool {
+	if !f.match(cleanName(hdr.Name), hdr.Typeflag == tar.TypeDir) {
+		return false
+	}
+	return hdr.Typeflag != tar.TypeLink || f.match(cleanName(hdr.Linkname), false)
+}
+
+// match returns whether name, a cleaned relative path, passes the filter:
+// neither it nor a directory above it is excluded, and either it or a
+// directory above it is included. Directories that may have included paths
+// in them are included too, so the included paths have their parents.
+func (f *filter) match(name string, dir bool) bool {
+	if name == "." {
+		return true
+	}
+	elems := strings.Split(name, "/")
+	for _, pattern := range f.exclude {
+		if matchGlob(pattern, elems, true, false) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if matchGlob(pattern, elems, true, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob returns whether the path elements name match the pattern
+// elements. "**" matches any number of elements, and the others are
+// path.Match patterns of a single element. With subtree, name also matches
+// if a directory above it does; with parent, if a path in name could.
+func matchGlob(pattern, name []string, subtree, parent bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == _globstar {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:], subtree, parent) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return parent
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0 || subtree
+}
+
+// ReadPatterns reads glob patterns for WithInclude or WithExclude from r, one
+// per line. Blank lines and lines starting with '#' are skipped.
+func ReadPatterns(r io.Reader) ([]string, error) {
+	var ret []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,144 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	paths := []struct {
+		name string
+		dir  bool
+	}{
+		{"etc", true},
+		{"etc/os-release", false},
+		{"usr", true},
+		{"usr/lib/libc.so", false},
+		{"usr/share", true},
+		{"usr/share/doc/README", false},
+		{"usr/share/man/man1", true},
+		{"usr/share/man/man1/ls.1", false},
+	}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "no patterns",
+			want: []string{"etc", "etc/os-release", "usr", "usr/lib/libc.so", "usr/share",
+				"usr/share/doc/README", "usr/share/man/man1", "usr/share/man/man1/ls.1"},
+		},
+		{
+			name:    "exclude subtrees",
+			exclude: []string{"/usr/share/doc", "usr/share/man/"},
+			want:    []string{"etc", "etc/os-release", "usr", "usr/lib/libc.so", "usr/share"},
+		},
+		{
+			name:    "exclude globstar",
+			exclude: []string{"**/*.so", "/usr/share/*/README"},
+			want: []string{"etc", "etc/os-release", "usr", "usr/share",
+				"usr/share/man/man1", "usr/share/man/man1/ls.1"},
+		},
+		{
+			name:    "include",
+			include: []string{"/etc"},
+			want:    []string{"etc", "etc/os-release"},
+		},
+		{
+			name:    "include keeps parents",
+			include: []string{"usr/**/*.1"},
+			want:    []string{"usr", "usr/share", "usr/share/man/man1", "usr/share/man/man1/ls.1"},
+		},
+		{
+			name:    "exclude wins",
+			include: []string{"/usr"},
+			exclude: []string{"/usr/share"},
+			want:    []string{"usr", "usr/lib/libc.so"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			var got []string
+			for _, p := range paths {
+				if f.match(p.name, p.dir) {
+					got = append(got, p.name)
+				}
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want != got: %q != %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := newFilter([]string{"etc/["}, nil); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("expected ErrBadPattern, got %v", err)
+	}
+}
+
+// linkTo is a hardlink with a target, which tartest.Hardlink does not have.
+type linkTo struct {
+	name, target string
+}
+
+func (l linkTo) Tar(tw *tar.Writer) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeLink,
+		Name:     l.name,
+		Linkname: l.target,
+		Mode:     0644,
+	})
+}
+
+func TestFlattenFilter(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "etc"},
+			file{Name: "etc/os-release"},
+			dir{Name: "usr/share/doc"},
+			file{Name: "usr/share/doc/README"},
+			linkTo{name: "etc/README", target: "usr/share/doc/README"},
+			linkTo{name: "etc/issue", target: "etc/os-release"},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer"},
+	}
+	var out bytes.Buffer
+	err := FlattenWithOptions(bytes.NewReader(image.Buffer().Bytes()), &out,
+		WithExclude("/usr/share/doc"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	var got []string
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		got = append(got, hdr.Name)
+	}
+	want := []string{"etc", "etc/os-release", "etc/issue"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+}
+
+func TestReadPatterns(t *testing.T) {
+	got, err := ReadPatterns(strings.NewReader("# docs\n/usr/share/doc\n\n  /usr/share/man  \n"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if want := []string{"/usr/share/doc", "/usr/share/man"}; !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+}
@@ -0,0 +1,411 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// _maxSymlinks is how many symlinks are followed when resolving a path,
+// like MAXSYMLINKS on Linux.
+const _maxSymlinks = 40
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// FS is a read-only view of the flattened image. It implements fs.FS,
+// fs.StatFS and fs.ReadDirFS, follows symlinks within the image like
+// os.DirFS does, and reads symlinks with ReadLink and Lstat.
+//
+// FS keeps the index of the merged file system in memory, and reads file
+// contents from the image when they are opened: uncompressed layers are
+// read in place, entries of compressed layers are decompressed into memory.
+// It is safe for concurrent use.
+type FS struct {
+	mu     sync.Mutex
+	rd     io.ReadSeeker
+	layers []nameOffset
+	root   *fsNode
+}
+
+// fsNode is a file in FS.
+type fsNode struct {
+	// hdr is the header of the entry, named by its path in FS. Hardlinks
+	// are resolved to regular files.
+	hdr *tar.Header
+	// layer is the index of the layer with the contents, and ordinal the
+	// number of the entry in it, counting from 1.
+	layer   int
+	ordinal int
+	// offset is where the contents are in the image, or -1 if they have to
+	// be decompressed or are stored as a sparse file.
+	offset int64
+	// children are the files in a directory.
+	children map[string]*fsNode
+}
+
+// NewFS indexes the image in rd, which must not be used by the caller while
+// FS is in use. Only WithLayers and the options that report anomalies and
+// progress, like WithWarnings, apply.
+func NewFS(rd io.ReadSeeker, opts ...Option) (*FS, error) {
+	ctx := context.Background()
+	img, err := openImage(ctx, rd, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]bool, len(img.layers))
+	for i, no := range img.layers {
+		if plain[i], err = isPlainLayer(rd, no); err != nil {
+			return nil, err
+		}
+	}
+
+	fsys := &FS{rd: rd, layers: img.layers, root: newFSDir(".")}
+	err = img.walk(ctx, func(i int, hdr *tar.Header, r *entryReader) error {
+		l := r.l
+		name := path.Clean("/" + hdr.Name)[1:]
+		if name == "" {
+			name = "."
+		}
+		h := *hdr
+		h.Name = name
+		node := &fsNode{hdr: &h, layer: -1, offset: -1}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+			node.layer, node.ordinal = i, l.n
+			if plain[node.layer] && !isSparse(hdr) {
+				node.offset = l.no.offset + l.lr.off
+			}
+			h.Typeflag = tar.TypeReg
+		case tar.TypeLink:
+			// like when extracting, the link is to the file as it is now
+			target, err := fsys.lookup(strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/"), true)
+			if err != nil || target.children != nil {
+				return nil
+			}
+			node.layer, node.ordinal, node.offset = target.layer, target.ordinal, target.offset
+			h.Typeflag, h.Linkname, h.Size = target.hdr.Typeflag, target.hdr.Linkname, target.hdr.Size
+		case tar.TypeDir:
+			node.children = map[string]*fsNode{}
+		}
+		fsys.root.add(name, node)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// isPlainLayer returns whether the layer blob no is not compressed.
+func isPlainLayer(rd io.ReadSeeker, no nameOffset) (bool, error) {
+	lr, err := newLayerReader(rd, no)
+	if err != nil {
+		return false, err
+	}
+	head := make([]byte, _magicLen)
+	n, err := io.ReadFull(lr, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return detectCompression(head[:n]) == "", nil
+}
+
+func newFSDir(name string) *fsNode {
+	return &fsNode{
+		hdr: &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     name,
+			Mode:     0755,
+			ModTime:  time.Unix(0, 0),
+		},
+		layer:    -1,
+		offset:   -1,
+		children: map[string]*fsNode{},
+	}
+}
+
+// add adds node at name under the directory n, creating the missing parent
+// directories. Like when extracting, a later entry replaces an earlier one,
+// except that directories keep their contents.
+func (n *fsNode) add(name string, node *fsNode) {
+	if name == "." {
+		if node.children != nil {
+			n.hdr = node.hdr
+		}
+		return
+	}
+	dir := n
+	elems := strings.Split(name, "/")
+	for i, elem := range elems[:len(elems)-1] {
+		child := dir.children[elem]
+		if child == nil || child.children == nil {
+			child = newFSDir(strings.Join(elems[:i+1], "/"))
+			dir.children[elem] = child
+		}
+		dir = child
+	}
+	base := elems[len(elems)-1]
+	if old := dir.children[base]; old != nil && old.children != nil && node.children != nil {
+		node.children = old.children
+	}
+	dir.children[base] = node
+}
+
+// lookup returns the file at name, which must be a valid fs path. Symlinks
+// are followed, except in the last element if lstat is set.
+func (fsys *FS) lookup(name string, lstat bool) (*fsNode, error) {
+	dirs := []*fsNode{fsys.root}
+	var elems []string
+	if name != "." && name != "" {
+		elems = strings.Split(name, "/")
+	}
+	var links int
+	for len(elems) > 0 {
+		elem := elems[0]
+		elems = elems[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(dirs) > 1 {
+				dirs = dirs[:len(dirs)-1]
+			}
+			continue
+		}
+		dir := dirs[len(dirs)-1]
+		if dir.children == nil {
+			return nil, fs.ErrNotExist
+		}
+		child := dir.children[elem]
+		if child == nil {
+			return nil, fs.ErrNotExist
+		}
+		if child.hdr.Typeflag == tar.TypeSymlink && (len(elems) > 0 || !lstat) {
+			if links++; links > _maxSymlinks {
+				return nil, errTooManySymlinks
+			}
+			target := child.hdr.Linkname
+			if strings.HasPrefix(target, "/") {
+				dirs = dirs[:1]
+			}
+			elems = append(strings.Split(target, "/"), elems...)
+			continue
+		}
+		dirs = append(dirs, child)
+	}
+	return dirs[len(dirs)-1], nil
+}
+
+// Open opens the named file, following symlinks.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	node, err := fsys.find("open", name, false)
+	if err != nil {
+		return nil, err
+	}
+	if node.children != nil {
+		return &fsDir{node: node, entries: node.readDir()}, nil
+	}
+	return &fsFile{fsys: fsys, node: node}, nil
+}
+
+// Stat returns the fs.FileInfo of the named file, following symlinks.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	node, err := fsys.find("stat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.hdr.FileInfo(), nil
+}
+
+// Lstat is like Stat, but does not follow the named file if it is a
+// symlink. It has the signature of Lstat in fs.ReadLinkFS of newer Go
+// versions.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	node, err := fsys.find("lstat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return node.hdr.FileInfo(), nil
+}
+
+// ReadLink returns the target of the named symlink. It has the signature of
+// ReadLink in fs.ReadLinkFS of newer Go versions.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	node, err := fsys.find("readlink", name, true)
+	if err != nil {
+		return "", err
+	}
+	if node.hdr.Typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.hdr.Linkname, nil
+}
+
+// ReadDir reads the named directory, following symlinks, and returns its
+// entries sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := fsys.find("readdir", name, false)
+	if err != nil {
+		return nil, err
+	}
+	if node.children == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	return node.readDir(), nil
+}
+
+func (fsys *FS) find(op, name string, lstat bool) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node, err := fsys.lookup(name, lstat)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return node, nil
+}
+
+// readDir returns the entries of a directory, sorted by name.
+func (n *fsNode) readDir() []fs.DirEntry {
+	ret := make([]fs.DirEntry, 0, len(n.children))
+	for _, child := range n.children {
+		ret = append(ret, fs.FileInfoToDirEntry(child.hdr.FileInfo()))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name() < ret[j].Name() })
+	return ret
+}
+
+// contents returns a reader of the contents of a regular file.
+func (fsys *FS) contents(node *fsNode) (io.ReadSeeker, error) {
+	if node.layer == -1 || node.hdr.Size == 0 {
+		return bytes.NewReader(nil), nil
+	}
+	if node.offset != -1 {
+		return io.NewSectionReader(lockedReaderAt{fsys}, node.offset, node.hdr.Size), nil
+	}
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	ctx := context.Background()
+	l, err := openLayer(fsys.rd, fsys.layers[node.layer])
+	if err != nil {
+		return nil, err
+	}
+	for l.n < node.ordinal {
+		if _, err := l.next(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	buf, err := io.ReadAll(&entryReader{ctx: ctx, l: l, name: node.hdr.Name})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf), nil
+}
+
+// lockedReaderAt reads from the image of an FS, which is shared by all open
+// files.
+type lockedReaderAt struct {
+	fsys *FS
+}
+
+func (r lockedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.fsys.mu.Lock()
+	defer r.fsys.mu.Unlock()
+	if _, err := r.fsys.rd.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.fsys.rd, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// fsFile is an open file in FS, other than a directory. Its contents are
+// read when first needed.
+type fsFile struct {
+	fsys *FS
+	node *fsNode
+	r    io.ReadSeeker
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.node.hdr.FileInfo(), nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.r.Read(p)
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *fsFile) Close() error {
+	return nil
+}
+
+func (f *fsFile) open() error {
+	if f.r != nil {
+		return nil
+	}
+	r, err := f.fsys.contents(f.node)
+	if err != nil {
+		return &fs.PathError{Op: "read", Path: f.node.hdr.Name, Err: err}
+	}
+	f.r = r
+	return nil
+}
+
+// fsDir is an open directory in FS.
+type fsDir struct {
+	node    *fsNode
+	entries []fs.DirEntry
+	off     int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.node.hdr.FileInfo(), nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.hdr.Name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// ReadDir reads the directory like fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.off += n
+	return rest[:n], nil
+}
@@ -0,0 +1,100 @@
+package rootfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	layer0 := tarball{
+		dir{Name: "etc"},
+		file{Name: "etc/os-release", Contents: bytes.NewBufferString("ID=alpine\n")},
+		file{Name: "etc/shadow", Contents: bytes.NewBufferString("root:*")},
+		dir{Name: "usr/bin"},
+		file{Name: "usr/bin/busybox", Contents: bytes.NewBufferString("busybox")},
+	}
+	layer1 := tarball{
+		file{Name: "etc/os-release", Contents: bytes.NewBufferString("ID=debian\n")},
+		hardlink{Name: "etc/.wh.shadow"},
+		symlink{Name: "bin", Target: "usr/bin"},
+		symlink{Name: "usr/bin/sh", Target: "/usr/bin/busybox"},
+	}
+
+	for _, compress := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "gzip"}[compress], func(t *testing.T) {
+			blob := func(tb tarball) *bytes.Buffer {
+				if compress {
+					return tb.Gzip()
+				}
+				return tb.Buffer()
+			}
+			image := tarball{
+				file{Name: "blobs/layer0/layer", Contents: blob(layer0)},
+				file{Name: "blobs/layer1/layer", Contents: blob(layer1)},
+				manifest{"blobs/layer0/layer", "blobs/layer1/layer"},
+			}
+			fsys, err := NewFS(bytes.NewReader(image.Buffer().Bytes()))
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			if err := fstest.TestFS(fsys, "etc/os-release", "usr/bin/busybox", "usr/bin/sh"); err != nil {
+				t.Error(err)
+			}
+
+			got, err := fs.ReadFile(fsys, "bin/sh")
+			if err != nil || string(got) != "busybox" {
+				t.Errorf("unexpected bin/sh: %q, %v", got, err)
+			}
+			got, err = fs.ReadFile(fsys, "etc/os-release")
+			if err != nil || string(got) != "ID=debian\n" {
+				t.Errorf("unexpected etc/os-release: %q, %v", got, err)
+			}
+			if _, err := fsys.Stat("etc/shadow"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected etc/shadow to be whited out, got %v", err)
+			}
+			if target, err := fsys.ReadLink("usr/bin/sh"); err != nil || target != "/usr/bin/busybox" {
+				t.Errorf("unexpected usr/bin/sh target: %q, %v", target, err)
+			}
+			if fi, err := fsys.Lstat("bin"); err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+				t.Errorf("expected bin to be a symlink, got %v, %v", fi, err)
+			}
+
+			if node, _ := fsys.lookup("usr/bin/busybox", false); (node.offset == -1) != compress {
+				t.Errorf("expected plain layers to be read in place, got offset %d", node.offset)
+			}
+
+			f, err := fsys.Open("usr/bin/busybox")
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			defer f.Close()
+			if _, err := f.(io.Seeker).Seek(4, io.SeekStart); err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			if got, err := io.ReadAll(f); err != nil || string(got) != "box" {
+				t.Errorf("unexpected contents after seeking: %q, %v", got, err)
+			}
+		})
+	}
+
+	t.Run("symlink loop", func(t *testing.T) {
+		image := tarball{
+			file{Name: "blobs/layer0/layer", Contents: tarball{
+				symlink{Name: "loop", Target: "./loop"},
+			}.Buffer()},
+			manifest{"blobs/layer0/layer"},
+		}
+		fsys, err := NewFS(bytes.NewReader(image.Buffer().Bytes()))
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if _, err := fsys.Stat("loop"); !errors.Is(err, errTooManySymlinks) {
+			t.Errorf("expected errTooManySymlinks, got %v", err)
+		}
+	})
+}
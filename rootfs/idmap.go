@@ -0,0 +1,86 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDMapping maps a contiguous range of user or group ids in the image to a
+// range of ids on the host, like an entry in /etc/subuid or an LXC idmap.
+type IDMapping struct {
+	// ContainerID is the first id of the range in the image.
+	ContainerID int
+	// HostID is the id that ContainerID is mapped to.
+	HostID int
+	// Size is the number of ids in the range.
+	Size int
+}
+
+// ParseIDMapping parses a mapping in the form of
+// "<container id>:<host id>:<size>", e.g. "0:100000:65536".
+func ParseIDMapping(s string) (IDMapping, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return IDMapping{}, fmt.Errorf("invalid id mapping %q: want <container id>:<host id>:<size>", s)
+	}
+	var nums [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return IDMapping{}, fmt.Errorf("invalid id mapping %q: %q is not a non-negative number", s, part)
+		}
+		nums[i] = n
+	}
+	if nums[2] == 0 {
+		return IDMapping{}, fmt.Errorf("invalid id mapping %q: size must be positive", s)
+	}
+	return IDMapping{ContainerID: nums[0], HostID: nums[1], Size: nums[2]}, nil
+}
+
+// String stringifies an IDMapping in the format accepted by ParseIDMapping.
+func (m IDMapping) String() string {
+	return fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size)
+}
+
+// idMap is a table of IDMappings. A nil idMap maps every id to itself.
+type idMap []IDMapping
+
+// lookup maps a container id to a host id. ok is false if the id is not
+// covered by any mapping.
+func (m idMap) lookup(id int) (_ int, ok bool) {
+	if m == nil {
+		return id, true
+	}
+	for _, r := range m {
+		if id >= r.ContainerID && id-r.ContainerID < r.Size {
+			return r.HostID + id - r.ContainerID, true
+		}
+	}
+	return 0, false
+}
+
+// mapIDs shifts the owner of hdr through the configured id mappings.
+func (o *options) mapIDs(hdr *tar.Header) error {
+	if o.uidMap == nil && o.gidMap == nil {
+		return nil
+	}
+	uid, ok := o.uidMap.lookup(hdr.Uid)
+	if !ok {
+		if !o.clampIDs {
+			return fmt.Errorf("uid %d is not mapped", hdr.Uid)
+		}
+		uid = o.nobodyUID
+	}
+	gid, ok := o.gidMap.lookup(hdr.Gid)
+	if !ok {
+		if !o.clampIDs {
+			return fmt.Errorf("gid %d is not mapped", hdr.Gid)
+		}
+		gid = o.nobodyGID
+	}
+	hdr.Uid, hdr.Gid = uid, gid
+	hdr.Uname, hdr.Gname = "", ""
+	return nil
+}
@@ -0,0 +1,125 @@
+package rootfs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs/internal/tartest"
+)
+
+func TestParseIDMapping(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    IDMapping
+		wantErr string
+	}{
+		{
+			in:   "0:100000:65536",
+			want: IDMapping{ContainerID: 0, HostID: 100000, Size: 65536},
+		},
+		{
+			in:      "0:100000",
+			wantErr: `invalid id mapping "0:100000": want <container id>:<host id>:<size>`,
+		},
+		{
+			in:      "0:-1:10",
+			wantErr: `invalid id mapping "0:-1:10": "-1" is not a non-negative number`,
+		},
+		{
+			in:      "0:1:0",
+			wantErr: `invalid id mapping "0:1:0": size must be positive`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseIDMapping(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("want error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("want != got: %v != %v", tt.want, got)
+			}
+			if got.String() != tt.in {
+				t.Errorf("want != got: %q != %q", tt.in, got.String())
+			}
+		})
+	}
+}
+
+func TestFlattenIDMap(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "/", UID: 0},
+			file{Name: "/root", UID: 0},
+			file{Name: "/user", UID: 1000},
+			file{Name: "/nobody", UID: 65534},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer"},
+	}
+	uids := []IDMapping{
+		{ContainerID: 0, HostID: 100000, Size: 1001},
+		{ContainerID: 65534, HostID: 165534, Size: 1},
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    []extractable
+		wantErr string
+	}{
+		{
+			name: "all ids mapped",
+			opts: []Option{WithIDMap(uids, nil)},
+			want: []extractable{
+				dir{Name: "/", UID: 100000},
+				file{Name: "/root", UID: 100000},
+				file{Name: "/user", UID: 101000},
+				file{Name: "/nobody", UID: 165534},
+			},
+		},
+		{
+			name:    "unmapped id",
+			opts:    []Option{WithIDMap(uids[:1], nil)},
+			wantErr: "/nobody: uid 65534 is not mapped",
+		},
+		{
+			name: "unmapped id clamped to nobody",
+			opts: []Option{WithIDMap(uids[:1], nil), WithNobody(99, 99)},
+			want: []extractable{
+				dir{Name: "/", UID: 100000},
+				file{Name: "/root", UID: 100000},
+				file{Name: "/user", UID: 101000},
+				file{Name: "/nobody", UID: 99},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := bytes.NewReader(image.Buffer().Bytes())
+			var out bytes.Buffer
+			err := FlattenWithOptions(in, &out, tt.opts...)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("want error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := tartest.Extract(t, &out)
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want != got: %v != %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type (
+	// ManifestEntry is an image in manifest.json. undocker flattens the
+	// first one.
+	ManifestEntry struct {
+		Config   string   `json:"Config"`
+		RepoTags []string `json:"RepoTags,omitempty"`
+		Layers   []string `json:"Layers"`
+	}
+
+	// ImageConfig is the part of the image configuration blob that is of
+	// interest to undocker.
+	ImageConfig struct {
+		Architecture string          `json:"architecture,omitempty"`
+		OS           string          `json:"os,omitempty"`
+		Created      string          `json:"created,omitempty"`
+		Config       ContainerConfig `json:"config"`
+		RootFS       struct {
+			Type    string   `json:"type"`
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+		History []History `json:"history,omitempty"`
+	}
+
+	// ContainerConfig is how a container of the image is to be run.
+	ContainerConfig struct {
+		User         string              `json:"User,omitempty"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+		Env          []string            `json:"Env,omitempty"`
+		Entrypoint   []string            `json:"Entrypoint,omitempty"`
+		Cmd          []string            `json:"Cmd,omitempty"`
+		WorkingDir   string              `json:"WorkingDir,omitempty"`
+		Labels       map[string]string   `json:"Labels,omitempty"`
+	}
+
+	// History is how a layer of the image was built.
+	History struct {
+		Created    string `json:"created,omitempty"`
+		CreatedBy  string `json:"created_by,omitempty"`
+		Comment    string `json:"comment,omitempty"`
+		EmptyLayer bool   `json:"empty_layer,omitempty"`
+	}
+
+	// LayerInfo describes a layer of the image.
+	LayerInfo struct {
+		// Name is the layer name as listed in manifest.json.
+		Name string `json:"name"`
+		// DiffID is the digest of the uncompressed layer, as listed in the
+		// image configuration, if it is there.
+		DiffID string `json:"diff_id,omitempty"`
+		// Compression is "gzip", "none", or an unsupported compression.
+		// Size and Entries are not known for unsupported compressions.
+		Compression    string `json:"compression"`
+		CompressedSize int64  `json:"compressed_size"`
+		Size           int64  `json:"size"`
+		Entries        int    `json:"entries"`
+	}
+
+	// ImageInfo describes an image, as returned by Inspect.
+	ImageInfo struct {
+		Manifest ManifestEntry `json:"manifest"`
+		// Config is nil if the image has no configuration blob.
+		Config *ImageConfig `json:"config,omitempty"`
+		Layers []LayerInfo  `json:"layers"`
+	}
+)
+
+// _compressionNone is the LayerInfo.Compression of uncompressed layers.
+const _compressionNone = "none"
+
+// Inspect describes the image that Flatten would flatten: its manifest
+// entry, configuration, and layers. Every layer is read once to count its
+// entries and uncompressed size.
+//
+// Only WithWarnings and WithStrict apply.
+func Inspect(rd io.ReadSeeker, opts ...Option) (*ImageInfo, error) {
+	return InspectContext(context.Background(), rd, opts...)
+}
+
+// InspectContext is like Inspect, but stops when ctx is done, like
+// FlattenContext.
+func InspectContext(ctx context.Context, rd io.ReadSeeker, opts ...Option) (*ImageInfo, error) {
+	arc, err := readArchive(ctx, rd, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	info := &ImageInfo{
+		Manifest: arc.manifest,
+		Layers:   make([]LayerInfo, len(arc.layers)),
+	}
+	info.Config, err = arc.readConfig(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, no := range arc.layers {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", no.name, err)
+		}
+		li, err := inspectLayer(rd, no)
+		if err != nil {
+			return nil, err
+		}
+		if info.Config != nil && len(info.Config.RootFS.DiffIDs) == len(arc.layers) {
+			li.DiffID = info.Config.RootFS.DiffIDs[i]
+		}
+		info.Layers[i] = li
+	}
+	return info, nil
+}
+
+// readConfig reads the image configuration blob, or returns nil if the image
+// has none.
+func (arc *archive) readConfig(rd io.ReadSeeker) (*ImageConfig, error) {
+	no, ok := arc.blobs[strings.TrimPrefix(arc.manifest.Config, "./")]
+	if !ok {
+		return nil, nil
+	}
+	lr, err := newLayerReader(rd, no)
+	if err != nil {
+		return nil, err
+	}
+	var config ImageConfig
+	if err := json.NewDecoder(lr).Decode(&config); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", no.name, err)
+	}
+	return &config, nil
+}
+
+// inspectLayer reads the layer blob no to describe it.
+func inspectLayer(rd io.ReadSeeker, no nameOffset) (LayerInfo, error) {
+	li := LayerInfo{Name: no.name, CompressedSize: no.size}
+	lr, err := newLayerReader(rd, no)
+	if err != nil {
+		return li, err
+	}
+	head := make([]byte, _magicLen)
+	n, err := io.ReadFull(lr, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return li, &CorruptLayerError{Op: "open", Layer: no.name, Err: err}
+	}
+	if n == 0 {
+		li.Compression = _compressionNone
+		return li, nil
+	}
+	if _, err := lr.Seek(0, io.SeekStart); err != nil {
+		return li, err
+	}
+
+	// an uncompressed layer is its own size; a compressed one is counted
+	// as it is read, including the padding after the last entry.
+	var r io.Reader = lr
+	var cr *byteCounter
+	switch li.Compression = detectCompression(head[:n]); li.Compression {
+	case "":
+		li.Compression = _compressionNone
+		li.Size = no.size
+	case _gzip:
+		gzipr, err := gzip.NewReader(lr)
+		if err != nil {
+			return li, &CorruptLayerError{Op: "open", Layer: no.name, Err: err}
+		}
+		defer gzipr.Close()
+		cr = &byteCounter{r: gzipr}
+		r = cr
+	default:
+		return li, nil
+	}
+	tr := tar.NewReader(r)
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return li, &CorruptLayerError{Op: "decode", Layer: no.name, Offset: lr.off, Err: err}
+		}
+		li.Entries++
+	}
+	if cr != nil {
+		if _, err := io.Copy(io.Discard, cr); err != nil {
+			return li, &CorruptLayerError{Op: "read", Layer: no.name, Offset: lr.off, Err: err}
+		}
+		li.Size = cr.n
+	}
+	return li, nil
+}
+
+// byteCounter counts the bytes read from r.
+type byteCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,98 @@
+package rootfs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	layer0 := tarball{
+		dir{Name: "etc"},
+		file{Name: "etc/passwd", Contents: bytes.NewBufferString("root:x:0:0")},
+	}
+	layer1 := tarball{
+		file{Name: "app", Contents: bytes.NewBufferString("app")},
+	}
+	config := `{
+		"architecture": "amd64",
+		"os": "linux",
+		"config": {
+			"User": "app",
+			"ExposedPorts": {"80/tcp": {}},
+			"Env": ["PATH=/bin"],
+			"Entrypoint": ["/app"],
+			"Cmd": ["--serve"],
+			"WorkingDir": "/srv",
+			"Labels": {"a": "b"}
+		},
+		"rootfs": {"type": "layers", "diff_ids": ["sha256:0", "sha256:1"]},
+		"history": [
+			{"created_by": "ADD base"},
+			{"created_by": "ENV PATH=/bin", "empty_layer": true},
+			{"created_by": "COPY app"}
+		]
+	}`
+	image := tarball{
+		file{Name: "blobs/sha256/0", Contents: layer0.Gzip()},
+		file{Name: "blobs/sha256/1", Contents: layer1.Buffer()},
+		file{Name: "blobs/sha256/config", Contents: bytes.NewBufferString(config)},
+		file{Name: "manifest.json", Contents: bytes.NewBufferString(`[{
+			"Config": "blobs/sha256/config",
+			"RepoTags": ["app:latest"],
+			"Layers": ["blobs/sha256/0", "blobs/sha256/1"]
+		}]`)},
+	}
+
+	got, err := Inspect(bytes.NewReader(image.Buffer().Bytes()))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	wantManifest := ManifestEntry{
+		Config:   "blobs/sha256/config",
+		RepoTags: []string{"app:latest"},
+		Layers:   []string{"blobs/sha256/0", "blobs/sha256/1"},
+	}
+	if !reflect.DeepEqual(wantManifest, got.Manifest) {
+		t.Errorf("want != got: %+v != %+v", wantManifest, got.Manifest)
+	}
+
+	wantConfig := ContainerConfig{
+		User:         "app",
+		ExposedPorts: map[string]struct{}{"80/tcp": {}},
+		Env:          []string{"PATH=/bin"},
+		Entrypoint:   []string{"/app"},
+		Cmd:          []string{"--serve"},
+		WorkingDir:   "/srv",
+		Labels:       map[string]string{"a": "b"},
+	}
+	if got.Config == nil || !reflect.DeepEqual(wantConfig, got.Config.Config) {
+		t.Fatalf("want != got: %+v != %+v", wantConfig, got.Config)
+	}
+	if n := len(got.Config.History); n != 3 || !got.Config.History[1].EmptyLayer {
+		t.Errorf("unexpected history: %+v", got.Config.History)
+	}
+
+	wantLayers := []LayerInfo{
+		{
+			Name:           "blobs/sha256/0",
+			DiffID:         "sha256:0",
+			Compression:    "gzip",
+			CompressedSize: int64(layer0.Gzip().Len()),
+			Size:           int64(layer0.Buffer().Len()),
+			Entries:        2,
+		},
+		{
+			Name:           "blobs/sha256/1",
+			DiffID:         "sha256:1",
+			Compression:    "none",
+			CompressedSize: int64(layer1.Buffer().Len()),
+			Size:           int64(layer1.Buffer().Len()),
+			Entries:        1,
+		},
+	}
+	if !reflect.DeepEqual(wantLayers, got.Layers) {
+		t.Errorf("want != got: %+v != %+v", wantLayers, got.Layers)
+	}
+}
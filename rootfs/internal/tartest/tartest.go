@@ -40,6 +40,13 @@ type (
 		Name string
 		UID  int
 	}
+
+	// Symlink is a representation of a symlink
+	Symlink struct {
+		Name   string
+		Target string
+		UID    int
+	}
 )
 
 // Buffer returns a byte buffer
@@ -110,6 +117,17 @@ func (h Hardlink) Tar(tw *tar.Writer) error {
 	})
 }
 
+// Tar tars the Symlink
+func (s Symlink) Tar(tw *tar.Writer) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     s.Name,
+		Linkname: s.Target,
+		Mode:     0777,
+		Uid:      s.UID,
+	})
+}
+
 // Extract extracts a tarball to a slice of extractables
 func Extract(t *testing.T, r io.Reader) []Extractable {
 	t.Helper()
@@ -130,6 +148,8 @@ func Extract(t *testing.T, r io.Reader) []Extractable {
 			elem = Dir{Name: hdr.Name, UID: hdr.Uid}
 		case tar.TypeLink:
 			elem = Hardlink{Name: hdr.Name}
+		case tar.TypeSymlink:
+			elem = Symlink{Name: hdr.Name, Target: hdr.Linkname, UID: hdr.Uid}
 		case tar.TypeReg:
 			f := File{Name: hdr.Name, UID: hdr.Uid}
 			if hdr.Size > 0 {
@@ -15,6 +15,7 @@ func TestTarball(t *testing.T) {
 		File{Name: "entrypoint.sh", Contents: bytes.NewBufferString("bye")},
 		Dir{Name: "bin"},
 		Hardlink{Name: "entrypoint2"},
+		Symlink{Name: "entrypoint3", Target: "entrypoint.sh"},
 	}
 
 	got := Extract(t, img.Buffer())
@@ -23,6 +24,7 @@ func TestTarball(t *testing.T) {
 		File{Name: "entrypoint.sh", Contents: bytes.NewBufferString("bye")},
 		Dir{Name: "bin"},
 		Hardlink{Name: "entrypoint2"},
+		Symlink{Name: "entrypoint3", Target: "entrypoint.sh"},
 	}
 	if !reflect.DeepEqual(want, got) {
 		t.Errorf("tarball mismatch. want: %+v, got: %+v", want, got)
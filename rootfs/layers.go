@@ -0,0 +1,67 @@
+package rootfs
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// selectLayers restricts the layers of arc to the range of WithLayers.
+func (arc *archive) selectLayers(rd io.ReadSeeker, o *options) error {
+	first, last, err := arc.layerRange(rd, o)
+	if err != nil {
+		return err
+	}
+	arc.layers = arc.layers[first : last+1]
+	arc.first = first
+	return nil
+}
+
+// layerRange returns the indices of the first and the last layer in the
+// range of WithLayers.
+func (arc *archive) layerRange(rd io.ReadSeeker, o *options) (first, last int, _ error) {
+	first, last = 0, len(arc.layers)-1
+	if o.firstLayer == "" && o.lastLayer == "" {
+		return first, last, nil
+	}
+	config, err := arc.readConfig(rd)
+	if err != nil {
+		return 0, 0, err
+	}
+	var diffIDs []string
+	if config != nil && len(config.RootFS.DiffIDs) == len(arc.layers) {
+		diffIDs = config.RootFS.DiffIDs
+	}
+
+	if o.firstLayer != "" {
+		if first, err = arc.findLayer(o.firstLayer, diffIDs); err != nil {
+			return 0, 0, err
+		}
+	}
+	if o.lastLayer != "" {
+		if last, err = arc.findLayer(o.lastLayer, diffIDs); err != nil {
+			return 0, 0, err
+		}
+	}
+	if first > last {
+		return 0, 0, fmt.Errorf("layer %d is above layer %d", first, last)
+	}
+	return first, last, nil
+}
+
+// findLayer returns the index of layer, given like in WithLayers.
+func (arc *archive) findLayer(layer string, diffIDs []string) (int, error) {
+	if i, err := strconv.Atoi(layer); err == nil {
+		if i < 0 || i >= len(arc.layers) {
+			return 0, fmt.Errorf("layer %d out of range, the image has %d", i, len(arc.layers))
+		}
+		return i, nil
+	}
+	for i, no := range arc.layers {
+		if layer == no.name || layer == layerDigest(no.name) ||
+			i < len(diffIDs) && layer == diffIDs[i] {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("layer %q not found", layer)
+}
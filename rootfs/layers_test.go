@@ -0,0 +1,124 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs/internal/tartest"
+)
+
+func TestWithLayers(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/sha256/aaa", Contents: tarball{
+			file{Name: "a"},
+			file{Name: "b", Contents: bytes.NewBufferString("from 0")},
+		}.Buffer()},
+		file{Name: "blobs/sha256/bbb", Contents: tarball{
+			hardlink{Name: ".wh.a"},
+			hardlink{Name: "d/.wh..wh..opq"},
+			file{Name: "b", Contents: bytes.NewBufferString("from 1")},
+		}.Buffer()},
+		file{Name: "blobs/sha256/ccc", Contents: tarball{
+			file{Name: "c"},
+		}.Buffer()},
+		file{Name: "blobs/sha256/config", Contents: bytes.NewBufferString(
+			`{"rootfs":{"type":"layers","diff_ids":["sha256:000","sha256:111","sha256:222"]}}`)},
+		file{Name: "manifest.json", Contents: bytes.NewBufferString(`[{
+			"Config": "blobs/sha256/config",
+			"Layers": ["blobs/sha256/aaa", "blobs/sha256/bbb", "blobs/sha256/ccc"]
+		}]`)},
+	}
+
+	tests := []struct {
+		name        string
+		first, last string
+		want        []string
+		wantErr     string
+	}{
+		{name: "all", want: []string{"b from 1", "c "}},
+		{name: "up to index", last: "0", want: []string{"a ", "b from 0"}},
+		{name: "up to diff_id", last: "sha256:111", want: []string{"b from 1"}},
+		{name: "from digest", first: "sha256:bbb", last: "1", want: []string{"b from 1"}},
+		{name: "by name", first: "blobs/sha256/ccc", want: []string{"c "}},
+		{name: "out of range", last: "3", wantErr: "layer 3 out of range, the image has 3"},
+		{name: "not found", last: "sha256:333", wantErr: `layer "sha256:333" not found`},
+		{name: "reversed", first: "2", last: "1", wantErr: "layer 2 is above layer 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := Walk(bytes.NewReader(image.Buffer().Bytes()), func(hdr *tar.Header, r io.Reader) error {
+				b, err := io.ReadAll(r)
+				got = append(got, fmt.Sprintf("%s %s", hdr.Name, b))
+				return err
+			}, WithLayers(tt.first, tt.last))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want != got: %q != %q", tt.want, got)
+			}
+		})
+	}
+
+	// the whiteouts of the lowest selected layer are for the layers below
+	// it, so they are neither in the output nor worth a warning.
+	var out bytes.Buffer
+	err := FlattenWithOptions(bytes.NewReader(image.Buffer().Bytes()), &out, WithLayers("1", ""), WithStrict())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	want := []extractable{
+		file{Name: "b", Contents: bytes.NewBufferString("from 1")},
+		file{Name: "c"},
+	}
+	if got := tartest.Extract(t, &out); !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %v != %v", want, got)
+	}
+
+	// Lookup searches the selected layers only.
+	err = Lookup(bytes.NewReader(image.Buffer().Bytes()), "b", func(_ *tar.Header, r io.Reader) error {
+		if b, _ := io.ReadAll(r); string(b) != "from 0" {
+			t.Errorf("expected b from layer 0, got %q", b)
+		}
+		return nil
+	}, WithLayers("", "0"))
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	// layers are reported by their index in the manifest.
+	trace, err := Explain(bytes.NewReader(image.Buffer().Bytes()), "b", WithLayers("1", ""))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(trace.Events) != 1 || trace.Events[0].Layer != 1 {
+		t.Errorf("expected an event of layer 1, got %+v", trace.Events)
+	}
+	a, err := Analyze(bytes.NewReader(image.Buffer().Bytes()), WithLayers("1", ""))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(a.Layers) != 2 || a.Layers[0].Layer != 1 || a.Layers[1].Layer != 2 {
+		t.Errorf("expected layers 1 and 2, got %+v", a.Layers)
+	}
+	entries, err := List(bytes.NewReader(image.Buffer().Bytes()), WithLayers("1", ""))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	for _, e := range entries {
+		if want := map[string]int{"b": 1, "c": 2}[e.Header.Name]; e.Layer != want {
+			t.Errorf("expected %s from layer %d, got %d", e.Header.Name, want, e.Layer)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"strings"
+)
+
+// Entry is an entry of the flattened image, with the layer it comes from.
+type Entry struct {
+	Header *tar.Header
+	// Layer is the index of the layer in the manifest, and LayerName its
+	// name as listed there.
+	Layer     int
+	LayerName string
+	// Digest is the digest of the layer blob, e.g. "sha256:…", if its name
+	// is in the blobs/<algorithm>/<hex> form.
+	Digest string
+}
+
+// List returns the entries of the flattened image, in the order Flatten
+// would write them. The headers are as in the layers, like in Walk.
+// There is one entry per path: a directory that is in several layers is
+// listed where it first appears, with the entry of the topmost layer.
+func List(rd io.ReadSeeker, opts ...Option) ([]Entry, error) {
+	return ListContext(context.Background(), rd, opts...)
+}
+
+// ListContext is like List, but stops when ctx is done, like FlattenContext.
+func ListContext(ctx context.Context, rd io.ReadSeeker, opts ...Option) ([]Entry, error) {
+	img, err := openImage(ctx, rd, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	var ret []Entry
+	// pos maps a cleaned name to its entry in ret.
+	pos := map[string]int{}
+	err = img.walk(ctx, func(i int, hdr *tar.Header, _ *entryReader) error {
+		name := img.layers[i].name
+		e := Entry{
+			Header:    hdr,
+			Layer:     img.first + i,
+			LayerName: name,
+			Digest:    layerDigest(name),
+		}
+		cleaned := cleanName(hdr.Name)
+		if j, ok := pos[cleaned]; ok {
+			ret[j] = e
+			return nil
+		}
+		pos[cleaned] = len(ret)
+		ret = append(ret, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// layerDigest returns the digest of a blob from its name, e.g.
+// "sha256:abc" for "blobs/sha256/abc", or an empty string if the name is not
+// a digest.
+func layerDigest(name string) string {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(name, "./"), _blobPrefix+"/")
+	if !ok {
+		return ""
+	}
+	algo, hex, ok := strings.Cut(rest, "/")
+	if !ok || hex == "" || strings.Trim(hex, "0123456789abcdef") != "" {
+		return ""
+	}
+	return algo + ":" + hex
+}
@@ -0,0 +1,53 @@
+package rootfs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/sha256/aaa", Contents: tarball{
+			dir{Name: "etc"},
+			file{Name: "etc/passwd"},
+			file{Name: "etc/group"},
+		}.Buffer()},
+		file{Name: "blobs/sha256/bbb", Contents: tarball{
+			dir{Name: "./etc/"},
+			file{Name: "etc/passwd"},
+		}.Buffer()},
+		manifest{"blobs/sha256/aaa", "blobs/sha256/bbb"},
+	}
+
+	entries, err := List(bytes.NewReader(image.Buffer().Bytes()))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Header.Name+" "+e.Digest+" "+e.LayerName)
+	}
+	want := []string{
+		"./etc/ sha256:bbb blobs/sha256/bbb",
+		"etc/group sha256:aaa blobs/sha256/aaa",
+		"etc/passwd sha256:bbb blobs/sha256/bbb",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+}
+
+func TestLayerDigest(t *testing.T) {
+	for name, want := range map[string]string{
+		"blobs/sha256/abc":   "sha256:abc",
+		"./blobs/sha512/def": "sha512:def",
+		"blobs/layer0/layer": "",
+		"blobs/a/b/c":        "",
+		"abc/layer.tar":      "",
+	} {
+		if got := layerDigest(name); got != want {
+			t.Errorf("%s: want != got: %q != %q", name, want, got)
+		}
+	}
+}
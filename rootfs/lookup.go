@@ -0,0 +1,303 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+var errNotDir = errors.New("not a directory")
+
+// Lookup finds the file at name in the flattened image and calls fn with it,
+// without going through the whole image: the layers are searched from the
+// top, and the search stops at the first layer that has the file or makes
+// its directory opaque. The directories on the way to the file are looked up
+// in the same pass.
+// Symlinks are followed within the image, with absolute symlinks relative to
+// the image root, and hardlinks are resolved to their targets. If the file is
+// not in the image, the error wraps fs.ErrNotExist.
+//
+// Only WithWarnings, WithStrict and WithLayers apply.
+func Lookup(rd io.ReadSeeker, name string, fn WalkFunc, opts ...Option) error {
+	return LookupContext(context.Background(), rd, name, fn, opts...)
+}
+
+// LookupContext is like Lookup, but stops when ctx is done, like
+// FlattenContext.
+func LookupContext(
+	ctx context.Context,
+	rd io.ReadSeeker,
+	name string,
+	fn WalkFunc,
+	opts ...Option,
+) error {
+	o := newOptions(opts)
+	arc, err := readArchive(ctx, rd, o)
+	if err != nil {
+		return err
+	}
+	if err := arc.selectLayers(rd, o); err != nil {
+		return err
+	}
+	lk := &looker{
+		ctx:        ctx,
+		rd:         rd,
+		layers:     arc.layers,
+		dropLowest: arc.first > 0,
+	}
+	f, err := lk.resolve(path.Clean("/" + name)[1:])
+	if err == nil && f.hdr.Typeflag == tar.TypeLink {
+		f, err = lk.lstat(path.Clean("/" + f.hdr.Linkname)[1:], nil)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "lookup", Path: name, Err: err}
+	}
+	return lk.read(f, fn)
+}
+
+// found is an entry found by looker.
+type found struct {
+	// layer is the index of the layer, and ordinal the number of the entry
+	// in it, counting from 1. layer is -1 for directories that are only
+	// implied by the files in them.
+	layer   int
+	ordinal int
+	hdr     *tar.Header
+}
+
+// looker finds single files in the layers of an image.
+type looker struct {
+	ctx    context.Context
+	rd     io.ReadSeeker
+	layers []nameOffset
+
+	// dropLowest is whether there are layers below the selected ones; see
+	// newIndex.
+	dropLowest bool
+
+	// stats are the files looked up so far, by name; nil if there is no
+	// such file.
+	stats map[string]*found
+}
+
+// resolve finds the file at name, a cleaned relative path, following
+// symlinks.
+func (lk *looker) resolve(name string) (*found, error) {
+	var elems, resolved []string
+	if name != "" {
+		elems = strings.Split(name, "/")
+	}
+	var f *found
+	var links int
+	for len(elems) > 0 {
+		elem := elems[0]
+		elems = elems[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			f = nil
+			continue
+		}
+		var err error
+		f, err = lk.lstat(path.Join(path.Join(resolved...), elem), elems)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case f.hdr.Typeflag == tar.TypeSymlink:
+			if links++; links > _maxSymlinks {
+				return nil, errTooManySymlinks
+			}
+			if strings.HasPrefix(f.hdr.Linkname, "/") {
+				resolved = nil
+			}
+			elems = append(strings.Split(f.hdr.Linkname, "/"), elems...)
+			f = nil
+			continue
+		case len(elems) > 0 && f.hdr.Typeflag != tar.TypeDir:
+			return nil, errNotDir
+		}
+		resolved = append(resolved, elem)
+	}
+	if f == nil {
+		return lk.lstat(path.Join(resolved...), nil)
+	}
+	return f, nil
+}
+
+// lstat finds the file at name, a cleaned relative path, without following
+// symlinks. If name was not looked up yet, the files at name joined with
+// the elements of rest are looked up in the same pass over the layers,
+// since resolve is likely to need them next.
+func (lk *looker) lstat(name string, rest []string) (*found, error) {
+	if name == "" {
+		name = "."
+	}
+	f, ok := lk.stats[name]
+	if !ok {
+		names := []string{name}
+		for _, elem := range rest {
+			switch elem {
+			case "", ".":
+				continue
+			case "..":
+				name = path.Dir(name)
+			default:
+				name = path.Join(name, elem)
+			}
+			names = append(names, name)
+		}
+		if err := lk.stat(names); err != nil {
+			return nil, err
+		}
+		f = lk.stats[names[0]]
+	}
+	if f == nil {
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+// stat looks up the files at names, cleaned relative paths with "." being
+// the root, and records them in lk.stats. The layers are read from the top,
+// each at most once, until all files are found or hidden. The entries are
+// judged by an index of the layers read so far, like in Flatten.
+func (lk *looker) stat(names []string) error {
+	if lk.stats == nil {
+		lk.stats = map[string]*found{}
+	}
+	want := map[string]bool{}
+	for _, name := range names {
+		if _, ok := lk.stats[name]; !ok {
+			want[name] = true
+		}
+	}
+
+	idx := newIndex(lk.dropLowest)
+	for i := len(lk.layers) - 1; i >= 0 && len(want) > 0; i-- {
+		entries, err := lk.statLayer(i, want, idx)
+		if err != nil {
+			return err
+		}
+		idx.seal(len(lk.layers))
+
+		kept := map[string]*found{}
+		implicit := map[string]bool{}
+		for _, f := range entries {
+			if !idx.keep(i, f.hdr) {
+				continue
+			}
+			cleaned := cleanName(f.hdr.Name)
+			if want[cleaned] {
+				kept[cleaned] = f
+			}
+			for name := range want {
+				if name != cleaned && isPathPrefix(name, cleaned) {
+					implicit[name] = true
+				}
+			}
+		}
+
+		for name := range want {
+			switch {
+			case kept[name] != nil:
+				lk.stats[name] = kept[name]
+			case implicit[name]:
+				lk.stats[name] = &found{layer: -1, hdr: &tar.Header{
+					Typeflag: tar.TypeDir,
+					Name:     name,
+					Mode:     0755,
+				}}
+			case i > 0 && idx.whIgnore[i-1].HasPrefix(name):
+				// an opaque directory hides it in the lower layers.
+				lk.stats[name] = nil
+			default:
+				continue
+			}
+			delete(want, name)
+		}
+	}
+	for name := range want {
+		lk.stats[name] = nil
+	}
+	return nil
+}
+
+// statLayer reads layer i for stat and adds its entries to idx. It returns
+// the entries that are one of want or in one of them.
+func (lk *looker) statLayer(i int, want map[string]bool, idx *index) ([]*found, error) {
+	l, err := openLayer(lk.rd, lk.layers[i])
+	if err != nil {
+		return nil, err
+	}
+	var entries []*found
+	for {
+		hdr, err := l.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := lk.ctx.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", l.no.name, hdr.Name, err)
+		}
+		idx.add(i, hdr)
+
+		cleaned := cleanName(hdr.Name)
+		for name := range want {
+			if isPathPrefix(name, cleaned) {
+				h := *hdr
+				entries = append(entries, &found{layer: i, ordinal: l.n, hdr: &h})
+				break
+			}
+		}
+	}
+	if err := l.close(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// isPathPrefix returns whether name is dir or in it. Both are cleaned
+// relative paths, "." being the root.
+func isPathPrefix(dir, name string) bool {
+	return dir == "." || dir == name || strings.HasPrefix(name, dir+"/")
+}
+
+// read calls fn with the entry f.
+func (lk *looker) read(f *found, fn WalkFunc) error {
+	if f.layer == -1 {
+		return fn(f.hdr, bytes.NewReader(nil))
+	}
+	l, err := openLayer(lk.rd, lk.layers[f.layer])
+	if err != nil {
+		return err
+	}
+	for {
+		hdr, err := l.next()
+		if err == io.EOF {
+			return l.corrupt("read", fmt.Errorf("%s: %w", f.hdr.Name, io.ErrUnexpectedEOF))
+		}
+		if err != nil {
+			return err
+		}
+		if l.n < f.ordinal {
+			continue
+		}
+		if err := fn(hdr, &entryReader{ctx: lk.ctx, l: l, name: hdr.Name}); err != nil {
+			return err
+		}
+		return l.close()
+	}
+}
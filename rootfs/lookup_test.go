@@ -0,0 +1,201 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	layer0 := tarball{
+		dir{Name: "etc"},
+		file{Name: "etc/passwd", Contents: bytes.NewBufferString("root:x:0:0")},
+		file{Name: "etc/shadow", Contents: bytes.NewBufferString("root:*")},
+		file{Name: "opt/app/config", Contents: bytes.NewBufferString("v0")},
+		file{Name: "usr/lib/os-release", Contents: bytes.NewBufferString("ID=alpine")},
+	}
+	layer1 := tarball{
+		hardlink{Name: "etc/.wh.shadow"},
+		hardlink{Name: "opt/app/.wh..wh..opq"},
+		symlink{Name: "etc/os-release", Target: "../usr/lib/os-release"},
+		symlink{Name: "lib", Target: "/usr/lib"},
+		symlink{Name: "loop", Target: "loop"},
+		file{Name: "etc/hosts", Contents: bytes.NewBufferString("127.0.0.1")},
+	}
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: layer0.Gzip()},
+		file{Name: "blobs/layer1/layer", Contents: layer1.Buffer()},
+		manifest{"blobs/layer0/layer", "blobs/layer1/layer"},
+	}
+
+	tests := []struct {
+		name    string
+		image   tarball
+		want    string
+		wantIs  error
+		wantErr string
+	}{
+		{name: "etc/passwd", image: image, want: "root:x:0:0"},
+		{name: "/etc/hosts", image: image, want: "127.0.0.1"},
+		{name: "etc/os-release", image: image, want: "ID=alpine"},
+		{name: "lib/os-release", image: image, want: "ID=alpine"},
+		{name: "lib/../etc/passwd", image: image, want: "root:x:0:0"},
+		{name: "etc/shadow", image: image, wantIs: fs.ErrNotExist},
+		{name: "opt/app/config", image: image, wantIs: fs.ErrNotExist},
+		{name: "etc/passwd/x", image: image, wantErr: "lookup etc/passwd/x: not a directory"},
+		{name: "loop", image: image, wantIs: errTooManySymlinks},
+		{
+			name: "etc/hosts",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: bytes.NewBufferString("not a tarball")},
+				file{Name: "blobs/layer1/layer", Contents: layer1.Buffer()},
+				manifest{"blobs/layer0/layer", "blobs/layer1/layer"},
+			},
+			want: "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			var calls int
+			err := Lookup(bytes.NewReader(tt.image.Buffer().Bytes()), tt.name, func(hdr *tar.Header, r io.Reader) error {
+				calls++
+				_, err := io.Copy(&got, r)
+				return err
+			})
+			switch {
+			case tt.wantIs != nil:
+				if !errors.Is(err, tt.wantIs) {
+					t.Errorf("expected %v, got %v", tt.wantIs, err)
+				}
+				return
+			case tt.wantErr != "":
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("want != got: %s != %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			if calls != 1 || got.String() != tt.want {
+				t.Errorf("want != got: %q != %q (%d calls)", tt.want, got.String(), calls)
+			}
+		})
+	}
+}
+
+func TestLookupFlatten(t *testing.T) {
+	layer0 := tarball{
+		dir{Name: "etc/"},
+		file{Name: "etc/passwd", Contents: bytes.NewBufferString("root:x:0:0")},
+		file{Name: "etc/shadow", Contents: bytes.NewBufferString("root:*")},
+		file{Name: "opt/app/config", Contents: bytes.NewBufferString("v0")},
+		file{Name: "opt/app/data", Contents: bytes.NewBufferString("d0")},
+		file{Name: "tmp/a", Contents: bytes.NewBufferString("a0")},
+		file{Name: "var/log", Contents: bytes.NewBufferString("l0")},
+	}
+	layer1 := tarball{
+		hardlink{Name: ".wh.etc"},
+		hardlink{Name: "etc/.wh.shadow"},
+		hardlink{Name: "opt/app/.wh..wh..opq"},
+		file{Name: "opt/app/data", Contents: bytes.NewBufferString("d1")},
+		file{Name: "tmp/a", Contents: bytes.NewBufferString("a1")},
+		hardlink{Name: "tmp/.wh.a"},
+		hardlink{Name: "var/.wh.log"},
+	}
+	layer2 := tarball{
+		file{Name: "var/log", Contents: bytes.NewBufferString("l2")},
+	}
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: layer0.Buffer()},
+		file{Name: "blobs/layer1/layer", Contents: layer1.Buffer()},
+		file{Name: "blobs/layer2/layer", Contents: layer2.Buffer()},
+		manifest{"blobs/layer0/layer", "blobs/layer1/layer", "blobs/layer2/layer"},
+	}.Buffer().Bytes()
+
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		// present is in the flattened image, so the test is not vacuous.
+		present string
+	}{
+		{name: "all layers", present: "etc/passwd"},
+		{
+			name:    "upper layers",
+			opts:    []Option{WithLayers("blobs/layer1/layer", "")},
+			present: "opt/app/data",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			flat := flatFiles(t, image, tt.opts...)
+			if _, ok := flat[tt.present]; !ok {
+				t.Errorf("expected %s in the flattened image, got %v", tt.present, flat)
+			}
+			for _, name := range []string{
+				"etc/passwd",
+				"etc/shadow",
+				"etc/.wh.shadow",
+				"opt/app/config",
+				"opt/app/data",
+				"tmp/a",
+				"tmp/.wh.a",
+				"var/log",
+			} {
+				t.Run(name, func(t *testing.T) {
+					var got bytes.Buffer
+					err := Lookup(bytes.NewReader(image), name, func(hdr *tar.Header, r io.Reader) error {
+						_, err := io.Copy(&got, r)
+						return err
+					}, tt.opts...)
+					want, ok := flat[name]
+					if !ok {
+						if !errors.Is(err, fs.ErrNotExist) {
+							t.Errorf("%s is not in the flattened image, but Lookup returned %v", name, err)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatalf("expected nil error, got %v", err)
+					}
+					if got.String() != want {
+						t.Errorf("want != got: %q != %q", want, got.String())
+					}
+				})
+			}
+		})
+	}
+}
+
+// flatFiles flattens image and returns the contents of its regular files,
+// by name.
+func flatFiles(t *testing.T, image []byte, opts ...Option) map[string]string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := FlattenWithOptions(bytes.NewReader(image), &out, opts...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	flat := map[string]string{}
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			flat[hdr.Name] = string(contents)
+		}
+	}
+	return flat
+}
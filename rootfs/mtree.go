@@ -0,0 +1,83 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const _mtreeHeader = "#mtree\n"
+
+// writeMtree writes a single mtree(5) line describing hdr. Paths are
+// written in the "full path" form, relative to the root of the tarball.
+func writeMtree(w io.Writer, hdr *tar.Header) error {
+	var typ string
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeLink:
+		// mtree has no notion of hardlinks, so they are recorded as
+		// regular files, like libarchive does.
+		typ = "file"
+	case tar.TypeDir:
+		typ = "dir"
+	case tar.TypeSymlink:
+		typ = "link"
+	case tar.TypeChar:
+		typ = "char"
+	case tar.TypeBlock:
+		typ = "block"
+	case tar.TypeFifo:
+		typ = "fifo"
+	default:
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(mtreeQuote(mtreePath(hdr.Name)))
+	fmt.Fprintf(&sb, " type=%s uid=%d gid=%d mode=%#o", typ, hdr.Uid, hdr.Gid, hdr.Mode)
+	if hdr.Uname != "" {
+		fmt.Fprintf(&sb, " uname=%s", mtreeQuote(hdr.Uname))
+	}
+	if hdr.Gname != "" {
+		fmt.Fprintf(&sb, " gname=%s", mtreeQuote(hdr.Gname))
+	}
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		fmt.Fprintf(&sb, " size=%d", hdr.Size)
+	case tar.TypeSymlink:
+		fmt.Fprintf(&sb, " link=%s", mtreeQuote(hdr.Linkname))
+	case tar.TypeChar, tar.TypeBlock:
+		fmt.Fprintf(&sb, " device=native,%d,%d", hdr.Devmajor, hdr.Devminor)
+	}
+	fmt.Fprintf(&sb, " time=%d.%09d", hdr.ModTime.Unix(), hdr.ModTime.Nanosecond())
+	sb.WriteByte('\n')
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// mtreePath converts a tar entry name to an mtree path, e.g. "/bin/" to
+// "./bin".
+func mtreePath(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+	return "." + name
+}
+
+// mtreeQuote escapes whitespace, non-printable characters and the characters
+// meaningful to mtree(5) as backslash-prefixed octal, like libarchive does.
+func mtreeQuote(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '#' || c == '=' || c == '\\' {
+			fmt.Fprintf(&sb, "\\%03o", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,42 @@
+package rootfs
+
+import (
+	"bytes"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs/internal/tartest"
+)
+
+type symlink = tartest.Symlink
+
+func TestMtree(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "/", UID: 0},
+			dir{Name: "bin", UID: 0},
+			file{Name: "bin/sh", UID: 0, Contents: bytes.NewBufferString("#!")},
+			symlink{Name: "bin/bash", Target: "sh"},
+			hardlink{Name: "bin/ash"},
+			file{Name: "home/a b=c#d", UID: 1000},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer"},
+	}
+
+	var out, spec bytes.Buffer
+	in := bytes.NewReader(image.Buffer().Bytes())
+	if err := FlattenWithOptions(in, &out, WithMtree(&spec)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `#mtree
+. type=dir uid=0 gid=0 mode=0644 time=0.000000000
+./bin type=dir uid=0 gid=0 mode=0644 time=0.000000000
+./bin/sh type=file uid=0 gid=0 mode=0644 size=2 time=0.000000000
+./bin/bash type=link uid=0 gid=0 mode=0777 link=sh time=0.000000000
+./bin/ash type=file uid=0 gid=0 mode=0644 time=0.000000000
+./home/a\040b\075c\043d type=file uid=1000 gid=0 mode=0644 size=0 time=0.000000000
+`
+	if got := spec.String(); want != got {
+		t.Errorf("want != got:\n%s\n!=\n%s", want, got)
+	}
+}
@@ -0,0 +1,178 @@
+package rootfs
+
+import "io"
+
+type (
+	// Option adjusts how an image is flattened. See FlattenWithOptions.
+	Option func(*options)
+
+	options struct {
+		warnings  func(Warning)
+		strict    bool
+		mtree     io.Writer
+		uidMap    idMap
+		gidMap    idMap
+		clampIDs  bool
+		nobodyUID int
+		nobodyGID int
+
+		resolveNames bool
+
+		include []string
+		exclude []string
+
+		firstLayer string
+		lastLayer  string
+
+		root   string
+		prefix string
+
+		progress func(Progress)
+	}
+)
+
+// WithWarnings calls fn for every anomaly that is tolerated while flattening
+// the image. Without it, anomalies are tolerated silently.
+func WithWarnings(fn func(Warning)) Option {
+	return func(o *options) {
+		o.warnings = fn
+	}
+}
+
+// WithStrict turns every warning into an error: flattening stops at the
+// first anomaly, returning it as a Warning. It can be combined with
+// WithWarnings, which is called before stopping.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithMtree writes an mtree(5) specification of the output tarball to w. It
+// records the ownership, mode and device numbers of every entry, which are
+// lost when the tarball is extracted by an unprivileged user. The extracted
+// tree can later be repacked with the original metadata, e.g. with
+// `bsdtar -cf rootfs.tar @rootfs.mtree`.
+func WithMtree(w io.Writer) Option {
+	return func(o *options) {
+		o.mtree = w
+	}
+}
+
+// WithIDMap shifts the owner of every entry through the given user and group
+// id mappings, e.g. into a subordinate id range for a user-namespaced runtime.
+// An empty table leaves the respective ids unchanged. Ids that are not covered
+// by a mapping are an error, unless WithNobody is given. User and group names
+// are cleared from mapped entries, so tar does not map them back by name.
+func WithIDMap(uids, gids []IDMapping) Option {
+	return func(o *options) {
+		if len(uids) > 0 {
+			o.uidMap = idMap(uids)
+		}
+		if len(gids) > 0 {
+			o.gidMap = idMap(gids)
+		}
+	}
+}
+
+// WithNobody replaces user and group ids that are not covered by WithIDMap
+// with uid and gid instead of failing.
+func WithNobody(uid, gid int) Option {
+	return func(o *options) {
+		o.clampIDs = true
+		o.nobodyUID = uid
+		o.nobodyGID = gid
+	}
+}
+
+// WithResolvedNames sets the user and group names of every entry to the names
+// of its uid and gid in the image's own /etc/passwd and /etc/group, as they
+// are after all layers are applied. Names of ids that are not listed there are
+// cleared. Names are resolved before WithIDMap clears them.
+func WithResolvedNames() Option {
+	return func(o *options) {
+		o.resolveNames = true
+	}
+}
+
+// WithInclude writes only the paths that match one of the glob patterns, and
+// everything in the directories that do, e.g. "/etc" or "/usr/lib/**/*.so".
+// Directories that may have matching paths in them are written too, so the
+// matching paths have their parents. It can be given many times.
+//
+// Patterns are of paths in the image, with or without the leading slash.
+// "**" matches any number of path elements, and the rest is as in path.Match
+// within a single element.
+func WithInclude(patterns ...string) Option {
+	return func(o *options) {
+		o.include = append(o.include, patterns...)
+	}
+}
+
+// WithExclude does not write the paths that match one of the glob patterns,
+// nor anything in the directories that do, e.g. "/usr/share/doc" or
+// "/usr/share/locale/*". It takes precedence over WithInclude, and can be
+// given many times. The patterns are like in WithInclude. Hardlinks to paths
+// that are not written are not written either.
+func WithExclude(patterns ...string) Option {
+	return func(o *options) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// WithLayers flattens only the layers from first to last, inclusive, as if
+// the image had no others. Each of them is the index of a layer in the
+// manifest, counting from 0, its diff_id in the image configuration, the
+// digest of its blob, e.g. "sha256:…", or its name in the manifest. An empty
+// first is the lowest layer, and an empty last the topmost one. Whiteouts
+// only hide the files of the selected layers; the ones in the first selected
+// layer are left out of the output.
+//
+// It applies to everything that reads the merged file system, but not to
+// Inspect.
+func WithLayers(first, last string) Option {
+	return func(o *options) {
+		o.firstLayer = first
+		o.lastLayer = last
+	}
+}
+
+// WithRoot makes dir the root of the output: only the paths in dir are
+// written, with dir stripped from their names. Absolute symlinks and hardlinks
+// to paths in dir are rewritten to match. Links that point outside of dir,
+// including relative symlinks that climb out of it, are left out and reported
+// as WarningEscapingLink. Symlinks in dir itself are not followed, and
+// WithInclude and WithExclude match the paths before they are rewritten.
+func WithRoot(dir string) Option {
+	return func(o *options) {
+		o.root = dir
+	}
+}
+
+// WithPrefix puts every entry of the output in dir, e.g. "rootfs", rewriting
+// hardlinks to match. The directories of dir are written first, owned by
+// root with mode 0755, so the output extracts cleanly; the root directory of
+// the image, if it has an entry, is left out. Symlinks are left as
+// they are, since they are resolved in the root file system.
+func WithPrefix(dir string) Option {
+	return func(o *options) {
+		o.prefix = dir
+	}
+}
+
+// WithProgress calls fn as the image is being flattened: at the start of
+// every layer in every phase, after every entry, and once when done. fn is
+// called synchronously and often, so it should be quick.
+func WithProgress(fn func(Progress)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -0,0 +1,111 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	_etcPasswd = "/etc/passwd"
+	_etcGroup  = "/etc/group"
+)
+
+// idNames maps numeric ids to names, as listed in /etc/passwd or /etc/group.
+type idNames map[int]string
+
+// parseIDNames parses /etc/passwd or /etc/group. Both have the name in the
+// first and the numeric id in the third field. Like getpwuid(3), the first
+// entry of an id wins. Malformed lines are skipped.
+func parseIDNames(r io.Reader) (idNames, error) {
+	ret := idNames{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] == "" {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if _, ok := ret[id]; !ok {
+			ret[id] = fields[0]
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// readImageNames reads user and group names from the final versions of
+// /etc/passwd and /etc/group in the image. A missing file yields no names.
+func readImageNames(ctx context.Context, img *image) (users idNames, groups idNames, _ error) {
+	ret := make([]idNames, 2)
+	for i, fname := range []string{_etcPasswd, _etcGroup} {
+		contents, err := img.readFinalFile(ctx, fname)
+		if err != nil {
+			return nil, nil, err
+		}
+		ret[i], err = parseIDNames(strings.NewReader(contents))
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse %s: %w", fname, err)
+		}
+	}
+	return ret[0], ret[1], nil
+}
+
+// readFinalFile returns the contents of the regular file fname as it is in
+// the flattened image, or an empty string if it is not there.
+func (img *image) readFinalFile(ctx context.Context, fname string) (string, error) {
+	// entry names are not normalized, so "etc/passwd" and "./etc/passwd"
+	// are both candidates.
+	key, layer := "", -1
+	for name, i := range img.idx.file2layer {
+		if path.Clean("/"+name) != fname || i <= layer {
+			continue
+		}
+		if img.idx.keep(i, &tar.Header{Name: name, Typeflag: tar.TypeReg}) {
+			key, layer = name, i
+		}
+	}
+	if layer == -1 {
+		return "", nil
+	}
+
+	l, err := openLayer(img.rd, img.layers[layer])
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for {
+		hdr, err := l.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("%s: %s: %w", l.no.name, hdr.Name, err)
+		}
+		if hdr.Name != key {
+			continue
+		}
+		sb.Reset()
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if _, err := io.Copy(&sb, &entryReader{ctx: ctx, l: l, name: hdr.Name}); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), l.close()
+}
@@ -0,0 +1,110 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIDNames(t *testing.T) {
+	in := strings.Join([]string{
+		"root:x:0:0:root:/root:/bin/sh",
+		"# comment",
+		"",
+		"toor:x:0:0:root:/root:/bin/sh",
+		"broken:x:abc:0::/:/bin/false",
+		"short:x",
+		"nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin",
+	}, "\n")
+	got, err := parseIDNames(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := idNames{0: "root", 65534: "nobody"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %v != %v", want, got)
+	}
+}
+
+func TestResolvedNames(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "etc"},
+			file{Name: "etc/passwd", Contents: bytes.NewBufferString("root:x:0:0::/:/bin/sh\n")},
+			file{Name: "etc/group", Contents: bytes.NewBufferString("root:x:0:\n")},
+		}.Buffer()},
+		file{Name: "blobs/layer1/layer", Contents: tarball{
+			file{Name: "etc/passwd", Contents: bytes.NewBufferString(
+				"root:x:0:0::/:/bin/sh\napp:x:1000:1000::/:/bin/sh\n",
+			)},
+			rawHeader{
+				Typeflag: tar.TypeReg,
+				Name:     "app",
+				Uid:      1000,
+				Gid:      1000,
+				Uname:    "builder",
+				Gname:    "builder",
+			},
+			rawHeader{
+				Typeflag: tar.TypeReg,
+				Name:     "unknown",
+				Uid:      1,
+				Gid:      1,
+				Uname:    "daemon",
+				Gname:    "daemon",
+			},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer", "blobs/layer1/layer"},
+	}
+
+	names := func(opts ...Option) []string {
+		t.Helper()
+		in := bytes.NewReader(image.Buffer().Bytes())
+		var out bytes.Buffer
+		if err := FlattenWithOptions(in, &out, opts...); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ret := []string{}
+		tr := tar.NewReader(&out)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ret = append(ret, hdr.Name+" "+hdr.Uname+":"+hdr.Gname)
+		}
+		return ret
+	}
+
+	got := names(WithResolvedNames())
+	want := []string{
+		"etc root:root",
+		"etc/group root:root",
+		"etc/passwd root:root",
+		"app app:",
+		"unknown :",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+
+	// the resolved names are of the ids in the image, so mapping the ids
+	// clears them.
+	got = names(WithResolvedNames(), WithIDMap([]IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}, nil))
+	want = []string{
+		"etc :",
+		"etc/group :",
+		"etc/passwd :",
+		"app :",
+		"unknown :",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+}
@@ -0,0 +1,111 @@
+package rootfs
+
+import "io"
+
+// Phase is a stage of flattening an image.
+type Phase int
+
+const (
+	// PhaseIndexing is the first pass over the layers, which finds out
+	// which entries make it to the output.
+	PhaseIndexing Phase = iota
+	// PhaseWriting is the second pass over the layers, which writes the
+	// output.
+	PhaseWriting
+	// PhaseDone is reported once, after the output is written.
+	PhaseDone
+)
+
+// String stringifies a phase
+func (p Phase) String() string {
+	switch p {
+	case PhaseIndexing:
+		return "indexing"
+	case PhaseWriting:
+		return "writing"
+	case PhaseDone:
+		return "done"
+	}
+	return "unknown"
+}
+
+// Progress is a snapshot of flattening an image, reported by WithProgress.
+type Progress struct {
+	Phase Phase
+	// Layer is the index of the layer being processed, as listed in the
+	// manifest. LayerName is its name.
+	Layer     int
+	LayerName string
+	// Layers is the number of layers in the image.
+	Layers int
+	// BytesRead is the number of bytes read from the input so far. Since
+	// the layers are read twice, this grows past the size of the input.
+	BytesRead int64
+	// Entries is the number of entries written to the output so far.
+	Entries int
+}
+
+// progress keeps track of Progress and reports it, if requested.
+type progress struct {
+	fn func(Progress)
+	cr *countingReader
+	p  Progress
+}
+
+// newProgress returns a progress tracker and an input to read from, which
+// counts the bytes read if progress is to be reported.
+func newProgress(fn func(Progress), rd io.ReadSeeker) (*progress, io.ReadSeeker) {
+	if fn == nil {
+		return &progress{}, rd
+	}
+	cr := &countingReader{rs: rd}
+	return &progress{fn: fn, cr: cr}, cr
+}
+
+// layer reports the start of layer i in the given phase.
+func (p *progress) layer(phase Phase, i int, layers []nameOffset) {
+	p.p.Phase = phase
+	p.p.Layer = i
+	p.p.LayerName = layers[i].name
+	p.p.Layers = len(layers)
+	p.report()
+}
+
+// entry reports a processed entry. written is whether it was written to
+// the output.
+func (p *progress) entry(written bool) {
+	if written {
+		p.p.Entries++
+	}
+	p.report()
+}
+
+// done reports PhaseDone.
+func (p *progress) done() {
+	p.p.Phase = PhaseDone
+	p.report()
+}
+
+func (p *progress) report() {
+	if p.fn == nil {
+		return
+	}
+	p.p.BytesRead = p.cr.n
+	p.fn(p.p)
+}
+
+// countingReader counts the bytes read from the underlying io.ReadSeeker.
+type countingReader struct {
+	rs io.ReadSeeker
+	n  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.rs.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	return c.rs.Seek(offset, whence)
+}
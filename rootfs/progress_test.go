@@ -0,0 +1,65 @@
+package rootfs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "/"},
+			file{Name: "/file", Contents: bytes.NewBufferString("from 0")},
+		}.Buffer()},
+		file{Name: "blobs/layer1/layer", Contents: tarball{
+			file{Name: "/file", Contents: bytes.NewBufferString("from 1")},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer", "blobs/layer1/layer"},
+	}
+
+	var events []Progress
+	in := bytes.NewReader(image.Buffer().Bytes())
+	var out bytes.Buffer
+	if err := FlattenWithOptions(in, &out, WithProgress(func(p Progress) {
+		events = append(events, p)
+	})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type step struct {
+		Phase Phase
+		Layer int
+	}
+	var steps []step
+	for i, p := range events {
+		if p.Layers != 2 {
+			t.Errorf("event %d: expected 2 layers, got %d", i, p.Layers)
+		}
+		if i > 0 && p.BytesRead < events[i-1].BytesRead {
+			t.Errorf("event %d: bytes read went down: %d < %d", i, p.BytesRead, events[i-1].BytesRead)
+		}
+		s := step{Phase: p.Phase, Layer: p.Layer}
+		if len(steps) == 0 || steps[len(steps)-1] != s {
+			steps = append(steps, s)
+		}
+	}
+	wantSteps := []step{
+		{PhaseIndexing, 0},
+		{PhaseIndexing, 1},
+		{PhaseWriting, 0},
+		{PhaseWriting, 1},
+		{PhaseDone, 1},
+	}
+	if !reflect.DeepEqual(wantSteps, steps) {
+		t.Errorf("want != got: %v != %v", wantSteps, steps)
+	}
+
+	last := events[len(events)-1]
+	if last.Entries != 2 {
+		t.Errorf("expected 2 entries written, got %d", last.Entries)
+	}
+	if last.BytesRead == 0 {
+		t.Errorf("expected bytes to be read from the input")
+	}
+}
@@ -0,0 +1,60 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// reroot rewrites hdr from layer to be relative to root, a cleaned relative
+// path, as in WithRoot. It returns nil for the entries that are not written:
+// the ones outside of root, and the links that escape it.
+func (o *options) reroot(root, layer string, hdr *tar.Header) (*tar.Header, error) {
+	if root == "." {
+		return hdr, nil
+	}
+	name, ok := strip(root, cleanName(hdr.Name))
+	if !ok {
+		return nil, nil
+	}
+	h := *hdr
+	h.Name = name
+	if name == "." {
+		h.Name = "./"
+	} else if h.Typeflag == tar.TypeDir {
+		h.Name += "/"
+	}
+
+	var escapes bool
+	switch h.Typeflag {
+	case tar.TypeLink:
+		h.Linkname, ok = strip(root, cleanName(hdr.Linkname))
+		escapes = !ok
+	case tar.TypeSymlink:
+		if strings.HasPrefix(hdr.Linkname, "/") {
+			target, ok := strip(root, cleanName(hdr.Linkname))
+			escapes = !ok
+			h.Linkname = path.Clean("/" + target)
+		} else {
+			target := path.Join(path.Dir(cleanName(hdr.Name)), hdr.Linkname)
+			_, ok := strip(root, cleanName(target))
+			escapes = !ok
+		}
+	}
+	if escapes {
+		return nil, o.warn(WarningEscapingLink, layer, hdr.Name,
+			fmt.Sprintf("link to %s escapes /%s", hdr.Linkname, root))
+	}
+	return &h, nil
+}
+
+// strip returns name, a cleaned relative path, relative to root, and whether
+// it is in root at all.
+func strip(root, name string) (string, bool) {
+	if name == root {
+		return ".", true
+	}
+	rel, ok := strings.CutPrefix(name, root+"/")
+	return rel, ok
+}
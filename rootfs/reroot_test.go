@@ -0,0 +1,76 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestWithRoot(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "etc"},
+			file{Name: "etc/passwd"},
+			dir{Name: "opt/dist"},
+			file{Name: "opt/dist/app", Contents: bytes.NewBufferString("app")},
+			dir{Name: "opt/dist/bin"},
+			symlink{Name: "opt/dist/bin/app", Target: "/opt/dist/app"},
+			symlink{Name: "opt/dist/bin/rel", Target: "../app"},
+			symlink{Name: "opt/dist/bin/passwd", Target: "/etc/passwd"},
+			symlink{Name: "opt/dist/bin/climb", Target: "../../../etc/passwd"},
+			linkTo{name: "opt/dist/app2", target: "opt/dist/app"},
+			linkTo{name: "opt/dist/passwd", target: "etc/passwd"},
+			file{Name: "opt/distfile"},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer"},
+	}
+
+	var warnings []string
+	var out bytes.Buffer
+	err := FlattenWithOptions(bytes.NewReader(image.Buffer().Bytes()), &out,
+		WithRoot("/opt/dist/"),
+		WithWarnings(func(w Warning) {
+			warnings = append(warnings, w.String())
+		}))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	var got []string
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		got = append(got, fmt.Sprintf("%s %s", hdr.Name, hdr.Linkname))
+	}
+	want := []string{
+		"./ ",
+		"app ",
+		"bin/ ",
+		"bin/app /app",
+		"bin/rel ../app",
+		"app2 app",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+	wantWarnings := []string{
+		"blobs/layer0/layer: opt/dist/bin/passwd: link to /etc/passwd escapes /opt/dist",
+		"blobs/layer0/layer: opt/dist/bin/climb: link to ../../../etc/passwd escapes /opt/dist",
+		"blobs/layer0/layer: opt/dist/passwd: link to etc/passwd escapes /opt/dist",
+	}
+	if !reflect.DeepEqual(wantWarnings, warnings) {
+		t.Errorf("want != got: %q != %q", wantWarnings, warnings)
+	}
+
+	err = FlattenWithOptions(bytes.NewReader(image.Buffer().Bytes()), &bytes.Buffer{},
+		WithRoot("opt/dist"), WithStrict())
+	var w Warning
+	if !errors.As(err, &w) || w.Kind != WarningEscapingLink {
+		t.Errorf("expected a WarningEscapingLink, got %v", err)
+	}
+}
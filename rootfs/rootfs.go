@@ -4,48 +4,223 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 const (
 	_manifestJSON = "manifest.json"
+	_indexJSON    = "index.json"
 	_blobPrefix   = "blobs"
 	_whReaddir    = ".wh..wh..opq"
 	_whPrefix     = ".wh."
 )
 
-var _gzipMagic = []byte{0x1f, 0x8b}
+// _gzip is the only supported layer compression
+const _gzip = "gzip"
+
+var (
+	_gzipMagic = []byte{0x1f, 0x8b}
+	_zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	_xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// _magicLen is enough bytes to recognize any of the compressions
+const _magicLen = 10
 
 type (
-	dockerManifestJSON []struct {
-		Layers []string `json:"Layers"`
+	dockerManifestJSON []ManifestEntry
+
+	// ociIndexJSON is the OCI image index, which newer versions of docker
+	// save next to manifest.json.
+	ociIndexJSON struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
 	}
 
 	nameOffset struct {
 		name   string
 		offset int64
+		size   int64
 	}
 )
 
 // Flatten flattens a docker image to a tarball. The underlying io.Writer
 // should be an open file handle, which the caller is responsible for closing
 // themselves
-func Flatten(rd io.ReadSeeker, w io.Writer) (_err error) {
+func Flatten(rd io.ReadSeeker, w io.Writer) error {
+	return FlattenWithOptions(rd, w)
+}
+
+// FlattenWithOptions is like Flatten, but its behavior can be adjusted with
+// options.
+func FlattenWithOptions(rd io.ReadSeeker, w io.Writer, opts ...Option) error {
+	return FlattenContext(context.Background(), rd, w, opts...)
+}
+
+// FlattenContext is like FlattenWithOptions, but stops when ctx is done. The
+// returned error then wraps ctx.Err(), prefixed with the layer and the path
+// that were being processed.
+func FlattenContext(
+	ctx context.Context,
+	rd io.ReadSeeker,
+	w io.Writer,
+	opts ...Option,
+) (_err error) {
+	o := newOptions(opts)
+	f, err := newFilter(o.include, o.exclude)
+	if err != nil {
+		return err
+	}
+	img, err := openImage(ctx, rd, o)
+	if err != nil {
+		return err
+	}
+
+	out := &output{w: w, tw: tar.NewWriter(w), o: o, prefix: cleanName(o.prefix)}
+	defer func() {
+		// closing after a failure only complains about the unfinished
+		// entry, so it is not worth reporting.
+		if err := out.tw.Close(); _err == nil {
+			_err = err
+		}
+	}()
+	if o.resolveNames {
+		out.users, out.groups, err = readImageNames(ctx, img)
+		if err != nil {
+			return err
+		}
+	}
+	if o.mtree != nil {
+		if _, err := io.WriteString(o.mtree, _mtreeHeader); err != nil {
+			return fmt.Errorf("mtree: %w", err)
+		}
+	}
+	if err := out.writePrefix(); err != nil {
+		return err
+	}
+	root := cleanName(o.root)
+	return img.walk(ctx, func(i int, hdr *tar.Header, r *entryReader) error {
+		if !f.keep(hdr) {
+			return nil
+		}
+		hdr, err := o.reroot(root, img.layers[i].name, hdr)
+		if hdr == nil || err != nil {
+			return err
+		}
+		return out.writeFile(r, hdr)
+	})
+}
+
+// image is a docker image, indexed for walking its merged file system.
+type image struct {
+	rd     io.ReadSeeker
+	prog   *progress
+	layers []nameOffset
+	idx    *index
+	// first is the index of the lowest of layers in the manifest; see
+	// archive.
+	first int
+}
+
+// openImage reads the manifest of the image and indexes its layers, which
+// is the first pass over the layers.
+func openImage(ctx context.Context, rd io.ReadSeeker, o *options) (*image, error) {
+	var prog *progress
+	prog, rd = newProgress(o.progress, rd)
+	arc, err := readArchive(ctx, rd, o)
+	if err != nil {
+		return nil, err
+	}
+	if err := arc.selectLayers(rd, o); err != nil {
+		return nil, err
+	}
+	layers := arc.layers
+	idx := newIndex(arc.first > 0)
+
+	// iterate over all files, construct the index
+	for i, no := range layers {
+		prog.layer(PhaseIndexing, i, layers)
+		l, err := openLayer(rd, no)
+		if err != nil {
+			return nil, err
+		}
+		var nentries int
+		// seen are the entries of this layer, to find duplicates
+		seen := map[string]struct{}{}
+		for {
+			hdr, err := l.next()
+			if err == io.EOF {
+				if nentries == 0 {
+					if err := o.warn(WarningEmptyLayer, no.name, "", "empty layer"); err != nil {
+						return nil, err
+					}
+				}
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", no.name, hdr.Name, err)
+			}
+			nentries++
+			prog.entry(false)
+			if err := warnEntry(o, no.name, hdr, seen); err != nil {
+				return nil, err
+			}
+			if _, _, ok := whiteout(hdr); ok && i == 0 && arc.first == 0 {
+				if err := o.warn(WarningLowestLayerWhiteout, no.name, hdr.Name,
+					"whiteout in the lowest layer"); err != nil {
+					return nil, err
+				}
+			}
+			idx.add(i, hdr)
+		}
+		if err := l.close(); err != nil {
+			return nil, err
+		}
+	}
+	idx.seal(len(layers))
+
+	return &image{rd: rd, prog: prog, layers: layers, idx: idx, first: arc.first}, nil
+}
+
+// archive is the outer tarball of an image.
+type archive struct {
+	// manifest is the manifest entry of the image that is flattened.
+	manifest ManifestEntry
+	// blobs are all blobs in the image, by name.
+	blobs map[string]nameOffset
+	// layers are the layers of the image, in the order they are laid down.
+	layers []nameOffset
+	// first is the index of the lowest of layers in the image, if only
+	// some of its layers are selected.
+	first int
+}
+
+// readArchive reads the manifest of the image and finds its blobs.
+func readArchive(ctx context.Context, rd io.ReadSeeker, o *options) (*archive, error) {
 	tr := tar.NewReader(rd)
-	var closer func() error
-	var err error
 
 	// layerOffsets maps a layer name (a9b123c0daa/layer.tar) to it's offset
-	layerOffsets := map[string]int64{}
+	// and size
+	layerOffsets := map[string]nameOffset{}
 
 	// manifest is the docker manifest in the image
 	var manifest dockerManifestJSON
 
+	// ociIndex is the OCI index in the image, if any
+	var ociIndex ociIndexJSON
+
 	// get layer offsets and manifest.json
 	for {
 		hdr, err := tr.Next()
@@ -53,7 +228,10 @@ func Flatten(rd io.ReadSeeker, w io.Writer) (_err error) {
 			break
 		}
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", hdr.Name, err)
 		}
 		if hdr.Typeflag != tar.TypeReg {
 			continue
@@ -62,22 +240,39 @@ func Flatten(rd io.ReadSeeker, w io.Writer) (_err error) {
 		case filepath.Clean(hdr.Name) == _manifestJSON:
 			dec := json.NewDecoder(tr)
 			if err := dec.Decode(&manifest); err != nil {
-				return fmt.Errorf("decode %s: %w", _manifestJSON, err)
+				return nil, fmt.Errorf("decode %s: %w", _manifestJSON, err)
+			}
+		case filepath.Clean(hdr.Name) == _indexJSON:
+			dec := json.NewDecoder(tr)
+			if err := dec.Decode(&ociIndex); err != nil {
+				return nil, fmt.Errorf("decode %s: %w", _indexJSON, err)
 			}
 		case strings.HasPrefix(hdr.Name, _blobPrefix):
 			here, err := rd.Seek(0, io.SeekCurrent)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			layerOffsets[strings.TrimPrefix(hdr.Name, "./")] = here
+			name := strings.TrimPrefix(hdr.Name, "./")
+			layerOffsets[name] = nameOffset{
+				name:   name,
+				offset: here,
+				size:   hdr.Size,
+			}
+		}
+	}
+
+	if len(manifest) != 0 {
+		if err := warnUnreferenced(o, layerOffsets, manifest, ociIndex); err != nil {
+			return nil, err
 		}
 	}
 
-	filteredLayerOffsets := make(map[string]int64)
+	blobs := layerOffsets
+	filteredLayerOffsets := make(map[string]nameOffset)
 	if len(manifest) != 0 {
 		for _, layer := range manifest[0].Layers {
-			if offset, ok := layerOffsets[layer]; ok {
-				filteredLayerOffsets[layer] = offset
+			if no, ok := layerOffsets[layer]; ok {
+				filteredLayerOffsets[layer] = no
 			}
 		}
 	}
@@ -85,116 +280,220 @@ func Flatten(rd io.ReadSeeker, w io.Writer) (_err error) {
 	layerOffsets = filteredLayerOffsets
 
 	if err := validateManifest(layerOffsets, manifest); err != nil {
-		return err
+		return nil, err
 	}
 
-	// enumerate layers the way they would be laid down in the image
-	layers := make([]nameOffset, len(layerOffsets))
+	// enumerate layers the way they would be laid down in the image. The
+	// same blob may be referenced more than once; every occurrence is
+	// applied at its own position.
+	layers := make([]nameOffset, len(manifest[0].Layers))
 	for i, name := range manifest[0].Layers {
+		no := layerOffsets[strings.TrimPrefix(name, "./")]
 		layers[i] = nameOffset{
 			name:   name,
-			offset: layerOffsets[strings.TrimPrefix(name, "./")],
+			offset: no.offset,
+			size:   no.size,
 		}
 	}
+	return &archive{manifest: manifest[0], blobs: blobs, layers: layers}, nil
+}
 
-	// file2layer maps a filename to layer number (index in "layers")
-	file2layer := map[string]int{}
-
-	// whreaddir maps `wh..wh..opq` file to a layer; see doc.go
-	whreaddir := map[string]int{}
-
-	// wh maps a filename to a layer until which it should be ignored,
-	// inclusively; see doc.go
-	wh := map[string]int{}
-
-	// iterate over all files, construct `file2layer`, `whreaddir`, `wh`
-	for i, no := range layers {
-		if _, err := rd.Seek(no.offset, io.SeekStart); err != nil {
-			return err
-		}
-		tr, closer, err = openTargz(rd)
-		if err != nil {
-			return err
-		}
-		for {
-			hdr, err := tr.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return fmt.Errorf("decode %s: %w", no.name, err)
-			}
-			if hdr.Typeflag == tar.TypeDir {
-				continue
-			}
-
-			// according to aufs documentation, whiteout files should be
-			// hardlinks. I saw at least one docker container using regular
-			// files for whiteouts.
-			if hdr.Typeflag == tar.TypeLink || hdr.Typeflag == tar.TypeReg {
-				basename := filepath.Base(hdr.Name)
-				basedir := filepath.Dir(hdr.Name)
-				if basename == _whReaddir {
-					whreaddir[basedir] = i
-					continue
-				} else if strings.HasPrefix(basename, _whPrefix) {
-					fname := strings.TrimPrefix(basename, _whPrefix)
-					wh[filepath.Join(basedir, fname)] = i
-					continue
-				}
-			}
-			file2layer[hdr.Name] = i
-		}
-		if err := closer(); err != nil {
-			return err
+// walk calls fn for every entry of the merged file system, in the order of
+// the layers, with the index of the layer it is from. It is the second pass
+// over the layers.
+func (img *image) walk(
+	ctx context.Context,
+	fn func(layer int, hdr *tar.Header, r *entryReader) error,
+) error {
+	return img.scan(ctx, func(i int, hdr *tar.Header, r *entryReader, v verdict) error {
+		if v != verdictKept {
+			return nil
 		}
-	}
-
-	// construct directories to whiteout, for each layer.
-	whIgnore := whiteoutDirs(whreaddir, len(layers))
+		return fn(i, hdr, r)
+	})
+}
 
-	tw := tar.NewWriter(w)
-	defer func() {
-		_err = errors.Join(_err, tw.Close())
-	}()
-	// iterate through all layers, all files, and write files.
-	for i, no := range layers {
-		if _, err := rd.Seek(no.offset, io.SeekStart); err != nil {
-			return err
-		}
-		tr, closer, err = openTargz(rd)
+// scan is like walk, but calls fn for every entry of every layer, with the
+// verdict on whether it is in the merged file system.
+func (img *image) scan(
+	ctx context.Context,
+	fn func(layer int, hdr *tar.Header, r *entryReader, v verdict) error,
+) error {
+	for i, no := range img.layers {
+		img.prog.layer(PhaseWriting, i, img.layers)
+		l, err := openLayer(img.rd, no)
 		if err != nil {
 			return err
 		}
 		for {
-			hdr, err := tr.Next()
+			hdr, err := l.next()
 			if err == io.EOF {
 				break
 			}
 			if err != nil {
-				return fmt.Errorf("decode %s: %w", no.name, err)
-			}
-			if layer, ok := wh[hdr.Name]; ok && layer >= i {
-				continue
-			}
-			if whIgnore[i].HasPrefix(hdr.Name) {
-				continue
+				return err
 			}
-			if hdr.Typeflag != tar.TypeDir && file2layer[hdr.Name] != i {
-				continue
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("%s: %s: %w", no.name, hdr.Name, err)
 			}
-			if err := writeFile(tr, tw, hdr); err != nil {
+			v := img.idx.verdict(i, hdr)
+			r := &entryReader{ctx: ctx, l: l, name: hdr.Name}
+			if err := fn(i, hdr, r, v); err != nil {
 				return err
 			}
+			img.prog.entry(v == verdictKept)
 		}
-		if err := closer(); err != nil {
+		if err := l.close(); err != nil {
 			return err
 		}
 	}
+	img.prog.done()
 	return nil
 }
 
-func writeFile(tr *tar.Reader, tw *tar.Writer, hdr *tar.Header) error {
+// index tells which entries of which layers make it to the flattened image.
+// The verdict on an entry only depends on the layers from its own up, so an
+// index of the topmost layers is enough to judge their entries.
+type index struct {
+	// file2layer maps a filename to layer number (index in "layers")
+	file2layer map[string]int
+
+	// wh maps a filename to a layer until which it should be ignored,
+	// inclusively; see doc.go
+	wh map[string]int
+
+	// whreaddir maps `wh..wh..opq` file to a layer; see doc.go
+	whreaddir map[string]int
+
+	// whIgnore are directories to whiteout, for each layer
+	whIgnore []*tree
+
+	// dropLowest is whether the layers below layer 0 are not selected, so
+	// the whiteouts of layer 0 are for them.
+	dropLowest bool
+}
+
+// newIndex returns an empty index. dropLowest is whether layer 0 is above
+// layers of the image that are not selected.
+func newIndex(dropLowest bool) *index {
+	return &index{
+		file2layer: map[string]int{},
+		wh:         map[string]int{},
+		whreaddir:  map[string]int{},
+		dropLowest: dropLowest,
+	}
+}
+
+// add indexes entry hdr of layer i. The layers may be added in any order,
+// but seal must be called before verdict.
+func (idx *index) add(i int, hdr *tar.Header) {
+	if hdr.Typeflag == tar.TypeDir {
+		return
+	}
+	basedir, fname, ok := whiteout(hdr)
+	switch {
+	case !ok:
+		setAbove(idx.file2layer, hdr.Name, i)
+		return
+	case fname == "":
+		setAbove(idx.whreaddir, basedir, i)
+	default:
+		setAbove(idx.wh, filepath.Join(basedir, fname), i)
+	}
+	if i == 0 && idx.dropLowest {
+		// it hides a file of a layer that is not selected. Like the
+		// whiteouts of the other layers, it is not in the flattened image.
+		setAbove(idx.file2layer, hdr.Name, -1)
+	}
+}
+
+// seal constructs the directories to whiteout, for each of nlayers layers,
+// from the layers added so far.
+func (idx *index) seal(nlayers int) {
+	idx.whIgnore = whiteoutDirs(idx.whreaddir, nlayers)
+}
+
+// setAbove sets m[name] to layer i, unless it is set to a layer above i.
+func setAbove(m map[string]int, name string, i int) {
+	if layer, ok := m[name]; !ok || i > layer {
+		m[name] = i
+	}
+}
+
+// whiteout returns the directory of the whiteout file hdr and the name it
+// hides, or an empty name if it makes the directory opaque. ok is false if
+// hdr is not a whiteout file.
+func whiteout(hdr *tar.Header) (basedir, fname string, ok bool) {
+	// according to aufs documentation, whiteout files should be
+	// hardlinks. I saw at least one docker container using regular
+	// files for whiteouts.
+	if hdr.Typeflag != tar.TypeLink && hdr.Typeflag != tar.TypeReg {
+		return "", "", false
+	}
+	basename := filepath.Base(hdr.Name)
+	if !strings.HasPrefix(basename, _whPrefix) {
+		return "", "", false
+	}
+	if basename != _whReaddir {
+		fname = strings.TrimPrefix(basename, _whPrefix)
+	}
+	return filepath.Dir(hdr.Name), fname, true
+}
+
+// verdict is whether an entry of a layer is in the flattened image, and if
+// not, why.
+type verdict int
+
+const (
+	// verdictKept is an entry that is in the flattened image.
+	verdictKept verdict = iota
+	// verdictWhiteout is an entry that is removed by a whiteout in the
+	// same or a later layer.
+	verdictWhiteout
+	// verdictOpaque is an entry in a directory that is made opaque in a
+	// later layer.
+	verdictOpaque
+	// verdictOverwritten is an entry that is replaced by an entry of the
+	// same name in a later layer.
+	verdictOverwritten
+)
+
+// keep returns whether entry hdr from layer i is in the flattened image.
+func (idx *index) keep(i int, hdr *tar.Header) bool {
+	return idx.verdict(i, hdr) == verdictKept
+}
+
+// verdict returns whether entry hdr from layer i is in the flattened image,
+// and if not, why.
+func (idx *index) verdict(i int, hdr *tar.Header) verdict {
+	if layer, ok := idx.wh[hdr.Name]; ok && layer >= i {
+		return verdictWhiteout
+	}
+	if idx.whIgnore[i].HasPrefix(hdr.Name) {
+		return verdictOpaque
+	}
+	if hdr.Typeflag == tar.TypeDir || idx.file2layer[hdr.Name] == i {
+		return verdictKept
+	}
+	return verdictOverwritten
+}
+
+// output writes entries to the flattened tarball.
+type output struct {
+	w  io.Writer
+	tw *tar.Writer
+	o  *options
+
+	// users and groups are the image's names of uids and gids, if
+	// resolving names was requested.
+	users  idNames
+	groups idNames
+
+	// prefix is the directory of every entry in the output, "." for none.
+	prefix string
+}
+
+func (out *output) writeFile(r io.Reader, hdr *tar.Header) error {
 	hdrOut := &tar.Header{
 		Typeflag: hdr.Typeflag,
 		Name:     hdr.Name,
@@ -210,13 +509,75 @@ func writeFile(tr *tar.Reader, tw *tar.Writer, hdr *tar.Header) error {
 		Devminor: hdr.Devminor,
 		Format:   tar.FormatGNU,
 	}
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		hdrOut.Typeflag = tar.TypeReg
+	}
+	if out.prefix != "." {
+		name := cleanName(hdr.Name)
+		if name == "." {
+			// writePrefix has written the directory already.
+			return nil
+		}
+		hdrOut.Name = out.prefix + "/" + name
+		if hdr.Typeflag == tar.TypeDir {
+			hdrOut.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			hdrOut.Linkname = path.Join(out.prefix, cleanName(hdr.Linkname))
+		}
+	}
+	return out.write(r, hdr, hdrOut)
+}
 
-	if err := tw.WriteHeader(hdrOut); err != nil {
+// writePrefix writes the directories of the WithPrefix prefix.
+func (out *output) writePrefix() error {
+	if out.prefix == "." {
+		return nil
+	}
+	var dir string
+	for _, elem := range strings.Split(out.prefix, "/") {
+		dir = path.Join(dir, elem)
+		hdr := &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir + "/",
+			Mode:     0755,
+			Format:   tar.FormatGNU,
+		}
+		hdrOut := *hdr
+		if err := out.write(nil, hdr, &hdrOut); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes hdr from the image as hdrOut, with the contents in r.
+func (out *output) write(r io.Reader, hdr, hdrOut *tar.Header) error {
+	if out.o.resolveNames {
+		hdrOut.Uname = out.users[hdrOut.Uid]
+		hdrOut.Gname = out.groups[hdrOut.Gid]
+	}
+
+	if err := out.o.mapIDs(hdrOut); err != nil {
+		return fmt.Errorf("%s: %w", hdr.Name, err)
+	}
+
+	if out.o.mtree != nil {
+		if err := writeMtree(out.o.mtree, hdrOut); err != nil {
+			return fmt.Errorf("mtree: %w", err)
+		}
+	}
+
+	if isSparse(hdr) {
+		return out.writeSparse(r, hdrOut)
+	}
+
+	if err := out.tw.WriteHeader(hdrOut); err != nil {
 		return err
 	}
 
 	if hdr.Typeflag == tar.TypeReg {
-		if _, err := io.Copy(tw, tr); err != nil {
+		if _, err := io.Copy(out.tw, r); err != nil {
 			return err
 		}
 	}
@@ -241,50 +602,253 @@ func whiteoutDirs(whreaddir map[string]int, nlayers int) []*tree {
 	return ret
 }
 
+// warnUnreferenced warns about blobs that are referenced neither by the
+// manifest nor by the OCI index.
+func warnUnreferenced(
+	o *options,
+	layerOffsets map[string]nameOffset,
+	manifest dockerManifestJSON,
+	ociIndex ociIndexJSON,
+) error {
+	referenced := map[string]struct{}{}
+	for _, m := range manifest {
+		referenced[strings.TrimPrefix(m.Config, "./")] = struct{}{}
+		for _, layer := range m.Layers {
+			referenced[strings.TrimPrefix(layer, "./")] = struct{}{}
+		}
+	}
+	for _, m := range ociIndex.Manifests {
+		algo, hex, _ := strings.Cut(m.Digest, ":")
+		referenced[path.Join(_blobPrefix, algo, hex)] = struct{}{}
+	}
+
+	var names []string
+	for name := range layerOffsets {
+		if _, ok := referenced[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := o.warn(WarningUnreferencedBlob, name, "",
+			"blob is not referenced by the manifest"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warnEntry warns about an entry of an unknown type, or one that is already
+// in seen, the entries of the same layer.
+func warnEntry(o *options, layer string, hdr *tar.Header, seen map[string]struct{}) error {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeLink, tar.TypeSymlink, tar.TypeChar,
+		tar.TypeBlock, tar.TypeDir, tar.TypeFifo, tar.TypeGNUSparse,
+		tar.TypeXGlobalHeader:
+	default:
+		msg := fmt.Sprintf("unknown entry type %q", hdr.Typeflag)
+		if err := o.warn(WarningUnknownType, layer, hdr.Name, msg); err != nil {
+			return err
+		}
+	}
+
+	name := path.Clean("/" + hdr.Name)
+	if _, ok := seen[name]; ok {
+		return o.warn(WarningDuplicateEntry, layer, hdr.Name, "duplicate entry")
+	}
+	seen[name] = struct{}{}
+	return nil
+}
+
 // validateManifest
 func validateManifest(
-	layerOffsets map[string]int64,
+	layerOffsets map[string]nameOffset,
 	manifest dockerManifestJSON,
 ) error {
 	if len(manifest) == 0 {
-		return fmt.Errorf("empty or missing manifest")
+		return ErrMissingManifest
 	}
 
 	for _, layer := range manifest[0].Layers {
 		if _, ok := layerOffsets[layer]; !ok {
-			return fmt.Errorf("%s defined in manifest, missing in tarball", layer)
+			return &MissingLayerError{Layer: layer}
 		}
 	}
 
 	return nil
 }
 
-// openTargz creates a tar reader from a targzip or tar.
+// openLayer opens the layer blob no for reading its entries.
+func openLayer(rd io.ReadSeeker, no nameOffset) (*layer, error) {
+	lr, err := newLayerReader(rd, no)
+	if err != nil {
+		return nil, err
+	}
+	tr, closer, err := openTargz(lr)
+	if err != nil {
+		var ucErr *UnsupportedCompressionError
+		if errors.As(err, &ucErr) {
+			ucErr.Layer = no.name
+			return nil, ucErr
+		}
+		return nil, &CorruptLayerError{Op: "open", Layer: no.name, Err: err}
+	}
+	return &layer{tr: tr, no: no, lr: lr, closer: closer}, nil
+}
+
+// layer is an open layer blob. Its errors are *CorruptLayerError.
+type layer struct {
+	tr     *tar.Reader
+	no     nameOffset
+	lr     *layerReader
+	closer func() error
+	// n is the number of entries read so far
+	n int
+}
+
+// next advances to the next entry, like tar.Reader.Next.
+func (l *layer) next() (*tar.Header, error) {
+	hdr, err := l.tr.Next()
+	if err != nil && err != io.EOF {
+		return nil, l.corrupt("decode", err)
+	}
+	if err == nil {
+		l.n++
+	}
+	return hdr, err
+}
+
+// close closes the decompressor, if any.
+func (l *layer) close() error {
+	if err := l.closer(); err != nil {
+		return l.corrupt("close", err)
+	}
+	return nil
+}
+
+func (l *layer) corrupt(op string, err error) error {
+	return &CorruptLayerError{Op: op, Layer: l.no.name, Offset: l.lr.off, Err: err}
+}
+
+// openTargz creates a tar reader from a targzip or tar. A zero-length file is
+// an empty layer.
 func openTargz(rs io.ReadSeeker) (*tar.Reader, func() error, error) {
 	// find out whether the given file is targz or tar
-	head := make([]byte, 2)
-	_, err := io.ReadFull(rs, head)
+	head := make([]byte, _magicLen)
+	n, err := io.ReadFull(rs, head)
 	switch {
-	case err == io.ErrUnexpectedEOF:
+	case err == io.EOF:
+		return tar.NewReader(rs), func() error { return nil }, nil
+	case err == io.ErrUnexpectedEOF && n < len(_gzipMagic):
 		return nil, nil, errors.New("tarball or gzipfile too small")
-	case err != nil:
+	case err != nil && err != io.ErrUnexpectedEOF:
 		return nil, nil, fmt.Errorf("read error: %w", err)
 	}
 
-	if _, err := rs.Seek(-2, io.SeekCurrent); err != nil {
+	if _, err := rs.Seek(int64(-n), io.SeekCurrent); err != nil {
 		return nil, nil, fmt.Errorf("seek: %w", err)
 	}
 
 	r := rs.(io.Reader)
 	closer := func() error { return nil }
-	if bytes.Equal(head, _gzipMagic) {
+	switch compression := detectCompression(head[:n]); compression {
+	case "":
+	case _gzip:
 		gzipr, err := gzip.NewReader(r)
 		if err != nil {
 			return nil, nil, fmt.Errorf("gzip.NewReader: %w", err)
 		}
 		closer = gzipr.Close
 		r = gzipr
+	default:
+		return nil, nil, &UnsupportedCompressionError{Compression: compression}
 	}
 
 	return tar.NewReader(r), closer, nil
 }
+
+// detectCompression returns the compression of a blob from its first bytes,
+// or an empty string for an uncompressed blob.
+func detectCompression(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, _gzipMagic):
+		return _gzip
+	case bytes.HasPrefix(head, _zstdMagic):
+		return "zstd"
+	case bytes.HasPrefix(head, _xzMagic):
+		return "xz"
+	// "BZh" may as well be the start of a file name in a tarball, so
+	// also check the block size and the block magic.
+	case len(head) >= 10 && bytes.HasPrefix(head, []byte("BZh")) &&
+		head[3] >= '1' && head[3] <= '9' && string(head[4:10]) == "1AY&SY":
+		return "bzip2"
+	}
+	return ""
+}
+
+// layerReader is an io.ReadSeeker over a single layer blob within the image.
+// Offsets are relative to the start of the blob, and reads stop at its end.
+type layerReader struct {
+	rs   io.ReadSeeker
+	base int64
+	size int64
+	off  int64
+}
+
+func newLayerReader(rs io.ReadSeeker, no nameOffset) (*layerReader, error) {
+	if _, err := rs.Seek(no.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &layerReader{rs: rs, base: no.offset, size: no.size}, nil
+}
+
+func (l *layerReader) Read(p []byte) (int, error) {
+	if l.off >= l.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.size-l.off {
+		p = p[:l.size-l.off]
+	}
+	n, err := l.rs.Read(p)
+	l.off += int64(n)
+	return n, err
+}
+
+func (l *layerReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += l.off
+	case io.SeekEnd:
+		offset += l.size
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+	if _, err := l.rs.Seek(l.base+offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	l.off = offset
+	return offset, nil
+}
+
+// entryReader reads the contents of the entry name from layer l. It fails
+// once ctx is done.
+type entryReader struct {
+	ctx  context.Context
+	l    *layer
+	name string
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	if err := e.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %s: %w", e.l.no.name, e.name, err)
+	}
+	n, err := e.l.tr.Read(p)
+	if err != nil && err != io.EOF {
+		err = e.l.corrupt("read", fmt.Errorf("%s: %w", e.name, err))
+	}
+	return n, err
+}
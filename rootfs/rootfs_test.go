@@ -3,7 +3,10 @@ package rootfs
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -33,10 +36,11 @@ func TestRootFS(t *testing.T) {
 	}
 
 	tests := []struct {
-		name    string
-		image   tarball
-		want    []extractable
-		wantErr string
+		name         string
+		image        tarball
+		want         []extractable
+		wantErr      string
+		wantWarnings []string
 	}{
 		{
 			name:  "empty tarball",
@@ -171,6 +175,82 @@ func TestRootFS(t *testing.T) {
 				file{Name: "a/fileb"},
 			},
 		},
+		{
+			name: "repeated layer is applied at every position",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: layer0.Buffer()},
+				file{Name: "blobs/layer1/layer", Contents: tarball{
+					hardlink{Name: ".wh.file"},
+				}.Buffer()},
+				manifest{
+					"blobs/layer0/layer",
+					"blobs/layer1/layer",
+					"blobs/layer0/layer",
+				},
+			},
+			want: []extractable{
+				dir{Name: "/", UID: 0},
+				dir{Name: "/", UID: 0},
+				file{Name: "/file", UID: 0, Contents: bytes.NewBufferString("from 0")},
+			},
+		},
+		{
+			name: "empty layers",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: layer0.Buffer()},
+				file{Name: "blobs/empty/layer"},
+				file{Name: "blobs/eoa/layer", Contents: tarball{}.Buffer()},
+				file{Name: "blobs/eoagz/layer", Contents: tarball{}.Gzip()},
+				manifest{
+					"blobs/layer0/layer",
+					"blobs/empty/layer",
+					"blobs/eoa/layer",
+					"blobs/eoagz/layer",
+				},
+			},
+			want: []extractable{
+				dir{Name: "/", UID: 0},
+				file{Name: "/file", UID: 0, Contents: bytes.NewBufferString("from 0")},
+			},
+			wantWarnings: []string{
+				"blobs/empty/layer: empty layer",
+				"blobs/eoa/layer: empty layer",
+				"blobs/eoagz/layer: empty layer",
+			},
+		},
+		{
+			name: "truncated layer",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: bytes.NewBufferString("x")},
+				manifest{"blobs/layer0/layer"},
+			},
+			wantErr: "open blobs/layer0/layer: tarball or gzipfile too small",
+		},
+		{
+			name: "odd image",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: tarball{
+					file{Name: "a", Contents: bytes.NewBufferString("first")},
+					file{Name: "./a", Contents: bytes.NewBufferString("second")},
+					hardlink{Name: ".wh.b"},
+					rawHeader{Name: "c", Typeflag: 'Z'},
+				}.Buffer()},
+				file{Name: "blobs/stray/layer", Contents: layer1.Buffer()},
+				manifest{"blobs/layer0/layer"},
+			},
+			want: []extractable{
+				file{Name: "a", Contents: bytes.NewBufferString("first")},
+				file{Name: "./a", Contents: bytes.NewBufferString("second")},
+				hardlink{Name: ".wh.b"},
+				nil, // unknown type
+			},
+			wantWarnings: []string{
+				"blobs/stray/layer: blob is not referenced by the manifest",
+				"blobs/layer0/layer: ./a: duplicate entry",
+				"blobs/layer0/layer: .wh.b: whiteout in the lowest layer",
+				"blobs/layer0/layer: c: unknown entry type 'Z'",
+			},
+		},
 		{
 			name: "archived layer",
 			image: tarball{
@@ -190,7 +270,10 @@ func TestRootFS(t *testing.T) {
 			in := bytes.NewReader(tt.image.Buffer().Bytes())
 			out := bytes.Buffer{}
 
-			err := Flatten(in, &out)
+			var warnings []string
+			err := FlattenWithOptions(in, &out, WithWarnings(func(w Warning) {
+				warnings = append(warnings, w.String())
+			}))
 			if tt.wantErr != "" {
 				if err == nil {
 					t.Fatal("expected error, got nil")
@@ -208,6 +291,115 @@ func TestRootFS(t *testing.T) {
 			if !reflect.DeepEqual(tt.want, got) {
 				t.Errorf("want != got: %v != %v", tt.want, got)
 			}
+			if !reflect.DeepEqual(tt.wantWarnings, warnings) {
+				t.Errorf("want warnings != got: %q != %q", tt.wantWarnings, warnings)
+			}
+		})
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "./"},
+			dir{Name: "./etc"},
+			file{Name: "./etc/passwd"},
+			linkTo{name: "./etc/passwd-", target: "./etc/passwd"},
+			symlink{Name: "./etc/mtab", Target: "/proc/mounts"},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer"},
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{
+			name: "prefix",
+			opts: []Option{WithPrefix("/lxc/rootfs/")},
+			want: []string{
+				"lxc/ 0755 ",
+				"lxc/rootfs/ 0755 ",
+				"lxc/rootfs/etc/ 0644 ",
+				"lxc/rootfs/etc/passwd 0644 ",
+				"lxc/rootfs/etc/passwd- 0644 lxc/rootfs/etc/passwd",
+				"lxc/rootfs/etc/mtab 0777 /proc/mounts",
+			},
+		},
+		{
+			name: "prefix and root",
+			opts: []Option{WithPrefix("rootfs"), WithRoot("etc")},
+			want: []string{
+				"rootfs/ 0755 ",
+				"rootfs/passwd 0644 ",
+				"rootfs/passwd- 0644 rootfs/passwd",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := FlattenWithOptions(bytes.NewReader(image.Buffer().Bytes()), &out, tt.opts...)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+			var got []string
+			tr := tar.NewReader(&out)
+			for {
+				hdr, err := tr.Next()
+				if err != nil {
+					break
+				}
+				got = append(got, fmt.Sprintf("%s %04o %s", hdr.Name, hdr.Mode, hdr.Linkname))
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want != got: %q != %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFlattenContext(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "/"},
+			file{Name: "/file", Contents: bytes.NewBufferString("from 0")},
+			file{Name: "/big", Contents: bytes.NewBuffer(make([]byte, 1<<20))},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer"},
+	}
+
+	tests := []struct {
+		name    string
+		after   int
+		wantErr string
+	}{
+		{
+			name:    "between entries",
+			after:   1,
+			wantErr: "blobs/layer0/layer: /file: context canceled",
+		},
+		{
+			name:    "within a file",
+			after:   64 << 10,
+			wantErr: "blobs/layer0/layer: /big: context canceled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			w := &cancelingWriter{after: tt.after, cancel: cancel}
+			in := bytes.NewReader(image.Buffer().Bytes())
+			err := FlattenContext(ctx, in, w)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("want != got: %s != %s", tt.wantErr, err.Error())
+			}
 		})
 	}
 }
@@ -225,3 +417,26 @@ func (m manifest) Tar(tw *tar.Writer) error {
 		Contents: bytes.NewBuffer(b),
 	}.Tar(tw)
 }
+
+// rawHeader is an entry with arbitrary header fields and no contents
+type rawHeader tar.Header
+
+func (h rawHeader) Tar(tw *tar.Writer) error {
+	hdr := tar.Header(h)
+	return tw.WriteHeader(&hdr)
+}
+
+// cancelingWriter calls cancel once at least `after` bytes are written
+type cancelingWriter struct {
+	n      int
+	after  int
+	cancel func()
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	if w.n >= w.after {
+		w.cancel()
+	}
+	return len(p), nil
+}
@@ -0,0 +1,289 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	_blockSize         = 512
+	_paxGNUSparse      = "GNU.sparse."
+	_paxGNUSparseMajor = "GNU.sparse.major"
+	_paxGNUSparseMinor = "GNU.sparse.minor"
+	_paxGNUSparseName  = "GNU.sparse.name"
+	_paxGNUSparseSize  = "GNU.sparse.realsize"
+)
+
+// sparseEntry is a fragment of data in a sparse file. Everything between the
+// fragments is a hole.
+type sparseEntry struct {
+	offset int64
+	length int64
+}
+
+// isSparse returns whether the entry was stored as a sparse file. archive/tar
+// transparently expands sparse files when reading, but keeps the original
+// type flag and PAX records around.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, _paxGNUSparse) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSparse writes a regular file in the PAX 1.0 sparse format. The
+// holes are found by looking for blocks of zeroes, which are not written.
+//
+// archive/tar can read, but not write sparse files, so the entry is written
+// by hand between the entries that are written by the tar.Writer.
+func (out *output) writeSparse(r io.Reader, hdr *tar.Header) (_err error) {
+	f, err := os.CreateTemp("", "undocker-sparse-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, f.Close(), os.Remove(f.Name()))
+	}()
+
+	frags, err := spill(f, r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", hdr.Name, err)
+	}
+	// a trailing hole is marked with an empty fragment at the end
+	if n := len(frags); n == 0 || frags[n-1].offset+frags[n-1].length < hdr.Size {
+		frags = append(frags, sparseEntry{offset: hdr.Size})
+	}
+
+	sparseMap := formatSparseMap(frags)
+	dataSize := int64(len(sparseMap))
+	for _, frag := range frags {
+		dataSize += frag.length
+	}
+
+	// GNU tar only understands PAX sparse headers in POSIX-format entries,
+	// so both headers are ustar, with PAX records for what does not fit.
+	records := map[string]string{
+		_paxGNUSparseMajor: "1",
+		_paxGNUSparseMinor: "0",
+		_paxGNUSparseName:  hdr.Name,
+		_paxGNUSparseSize:  strconv.FormatInt(hdr.Size, 10),
+	}
+	hdrData := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join("GNUSparseFile.0", path.Base(hdr.Name)),
+		Size:     dataSize,
+		Mode:     hdr.Mode,
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+		Uname:    hdr.Uname,
+		Gname:    hdr.Gname,
+		ModTime:  hdr.ModTime,
+	}
+	if !fitsOctal(int64(hdr.Uid), 8) {
+		records["uid"] = strconv.Itoa(hdr.Uid)
+	}
+	if !fitsOctal(int64(hdr.Gid), 8) {
+		records["gid"] = strconv.Itoa(hdr.Gid)
+	}
+	if !fitsOctal(dataSize, 12) {
+		records["size"] = strconv.FormatInt(dataSize, 10)
+	}
+	if len(hdr.Uname) > 31 {
+		records["uname"] = hdr.Uname
+	}
+	if len(hdr.Gname) > 31 {
+		records["gname"] = hdr.Gname
+	}
+	var paxData bytes.Buffer
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		paxData.WriteString(formatPAXRecord(k, records[k]))
+	}
+	hdrPAX := &tar.Header{
+		Typeflag: tar.TypeXHeader,
+		Name:     path.Join("PaxHeaders.0", path.Base(hdr.Name)),
+		Size:     int64(paxData.Len()),
+		Mode:     0644,
+	}
+
+	if err := out.tw.Flush(); err != nil {
+		return err
+	}
+	bw := &blockWriter{w: out.w}
+	bw.Write(formatUSTAR(hdrPAX))
+	bw.Write(paxData.Bytes())
+	bw.pad()
+	bw.Write(formatUSTAR(hdrData))
+	bw.Write(sparseMap)
+	if err := copyFragments(bw, f, frags); err != nil {
+		return fmt.Errorf("%s: %w", hdr.Name, err)
+	}
+	bw.pad()
+	return bw.err
+}
+
+// copyFragments copies the data fragments frags of f to w. The header
+// before them promises their lengths, so a short read is an error.
+func copyFragments(w io.Writer, f io.ReaderAt, frags []sparseEntry) error {
+	for _, frag := range frags {
+		n, err := io.Copy(w, io.NewSectionReader(f, frag.offset, frag.length))
+		if err != nil {
+			return err
+		}
+		if n != frag.length {
+			return fmt.Errorf("fragment at %d: %w", frag.offset, io.ErrUnexpectedEOF)
+		}
+	}
+	return nil
+}
+
+// spill copies r to f, leaving out blocks of zeroes, and returns the data
+// fragments that were written.
+func spill(f io.WriterAt, r io.Reader) ([]sparseEntry, error) {
+	var frags []sparseEntry
+	var offset int64
+	buf := make([]byte, 64*_blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for i := 0; i < n; i += _blockSize {
+			block := buf[i:min(i+_blockSize, n)]
+			if isZero(block) {
+				offset += int64(len(block))
+				continue
+			}
+			if _, err := f.WriteAt(block, offset); err != nil {
+				return nil, err
+			}
+			if last := len(frags) - 1; last >= 0 && frags[last].offset+frags[last].length == offset {
+				frags[last].length += int64(len(block))
+			} else {
+				frags = append(frags, sparseEntry{offset: offset, length: int64(len(block))})
+			}
+			offset += int64(len(block))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return frags, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// formatSparseMap formats the sparse map of the PAX 1.0 sparse format: the
+// number of fragments followed by offset and length of every fragment, all
+// newline-terminated decimals, padded to the block size.
+func formatSparseMap(frags []sparseEntry) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\n", len(frags))
+	for _, frag := range frags {
+		fmt.Fprintf(&buf, "%d\n%d\n", frag.offset, frag.length)
+	}
+	if pad := buf.Len() % _blockSize; pad != 0 {
+		buf.Write(make([]byte, _blockSize-pad))
+	}
+	return buf.Bytes()
+}
+
+// formatUSTAR formats a ustar header block. Fields that do not fit are
+// truncated or zeroed, and are expected to be in PAX records.
+func formatUSTAR(hdr *tar.Header) []byte {
+	blk := make([]byte, _blockSize)
+	octal := func(b []byte, n int64) {
+		if !fitsOctal(n, len(b)) {
+			n = 0
+		}
+		copy(b, fmt.Sprintf("%0*o\x00", len(b)-1, n))
+	}
+	str := func(b []byte, s string) {
+		copy(b[:len(b)-1], s)
+	}
+	str(blk[0:100], hdr.Name)
+	octal(blk[100:108], hdr.Mode)
+	octal(blk[108:116], int64(hdr.Uid))
+	octal(blk[116:124], int64(hdr.Gid))
+	octal(blk[124:136], hdr.Size)
+	octal(blk[136:148], max(hdr.ModTime.Unix(), 0))
+	blk[156] = hdr.Typeflag
+	copy(blk[257:263], "ustar\x00")
+	copy(blk[263:265], "00")
+	str(blk[265:297], hdr.Uname)
+	str(blk[297:329], hdr.Gname)
+
+	var chksum int64
+	copy(blk[148:156], "        ")
+	for _, c := range blk {
+		chksum += int64(c)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", chksum))
+	return blk
+}
+
+// fitsOctal returns whether n fits a NUL-terminated octal field of width
+// bytes.
+func fitsOctal(n int64, width int) bool {
+	return n >= 0 && n < 1<<(3*(width-1))
+}
+
+// blockWriter counts the bytes written to w and remembers the first error,
+// after which writes are no-ops.
+type blockWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	if bw.err != nil {
+		return 0, bw.err
+	}
+	n, err := bw.w.Write(p)
+	bw.n += int64(n)
+	bw.err = err
+	return n, err
+}
+
+// pad pads the output to the block size.
+func (bw *blockWriter) pad() {
+	if rem := bw.n % _blockSize; rem != 0 {
+		bw.Write(make([]byte, _blockSize-rem))
+	}
+}
+
+// formatPAXRecord formats a "%d %s=%s\n" record, where the leading decimal
+// is the length of the whole record, including itself.
+func formatPAXRecord(k, v string) string {
+	rec := " " + k + "=" + v + "\n"
+	size := len(rec) + len(strconv.Itoa(len(rec)))
+	// adding the length field may have made the length field longer
+	if size != len(rec)+len(strconv.Itoa(size)) {
+		size++
+	}
+	return strconv.Itoa(size) + rec
+}
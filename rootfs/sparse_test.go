@@ -0,0 +1,196 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSpill(t *testing.T) {
+	data := make([]byte, 10*_blockSize+3)
+	data[0] = 1
+	data[3*_blockSize] = 1
+	data[4*_blockSize+100] = 1
+	data[10*_blockSize+2] = 1
+
+	var f writerAt
+	got, err := spill(&f, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []sparseEntry{
+		{offset: 0, length: _blockSize},
+		{offset: 3 * _blockSize, length: 2 * _blockSize},
+		{offset: 10 * _blockSize, length: 3},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %v != %v", want, got)
+	}
+	if !bytes.Equal(data, f.buf[:len(data)]) {
+		t.Errorf("spilled data does not match the input")
+	}
+}
+
+func TestCopyFragments(t *testing.T) {
+	data := []byte("0123456789")
+	var out bytes.Buffer
+	err := copyFragments(&out, bytes.NewReader(data), []sparseEntry{
+		{offset: 1, length: 2},
+		{offset: 6, length: 4},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := out.String(); got != "126789" {
+		t.Errorf("want != got: %q != %q", "126789", got)
+	}
+
+	err = copyFragments(io.Discard, bytes.NewReader(data), []sparseEntry{
+		{offset: 8, length: 4},
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestFormatPAXRecord(t *testing.T) {
+	tests := []struct {
+		k, v string
+		want string
+	}{
+		{k: "path", v: "foo", want: "12 path=foo\n"},
+		{k: "k", v: "vvvv", want: "9 k=vvvv\n"},
+		{k: "k", v: "vvvvv", want: "11 k=vvvvv\n"},
+	}
+	for _, tt := range tests {
+		if got := formatPAXRecord(tt.k, tt.v); tt.want != got {
+			t.Errorf("want != got: %q != %q", tt.want, got)
+		}
+	}
+}
+
+func TestFlattenSparse(t *testing.T) {
+	contents := make([]byte, 1<<20)
+	copy(contents[4096:], "hello")
+	copy(contents[len(contents)-3:], "end")
+
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     "var/",
+		Mode:     0755,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	layer.Write(oldGNUSparse("var/db", 0600, 70, contents, []sparseEntry{
+		{offset: 4096, length: 4096},
+		{offset: int64(len(contents)) - 4096, length: 4096},
+		{offset: int64(len(contents)), length: 0},
+	}))
+	layer.Write(make([]byte, 2*_blockSize))
+
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: &layer},
+		manifest{"blobs/layer0/layer"},
+	}
+	var flat bytes.Buffer
+	if err := Flatten(bytes.NewReader(image.Buffer().Bytes()), &flat); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if flat.Len() > 16*_blockSize {
+		t.Errorf("expected a sparse tarball, got %d bytes", flat.Len())
+	}
+
+	tr := tar.NewReader(&flat)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name != "var/db" {
+			continue
+		}
+		if !isSparse(hdr) {
+			t.Errorf("expected %s to be sparse", hdr.Name)
+		}
+		if hdr.Uid != 70 || hdr.Mode != 0600 || hdr.Size != int64(len(contents)) {
+			t.Errorf("unexpected header: %+v", hdr)
+		}
+		got, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(contents, got) {
+			t.Errorf("contents of %s do not match", hdr.Name)
+		}
+	}
+	if want := []string{"var/", "var/db"}; !reflect.DeepEqual(want, names) {
+		t.Errorf("want != got: %q != %q", want, names)
+	}
+}
+
+// oldGNUSparse returns an old GNU sparse ('S') entry of contents, as written
+// by GNU tar, with the data of frags.
+func oldGNUSparse(name string, mode, uid int64, contents []byte, frags []sparseEntry) []byte {
+	octal := func(b []byte, n int64) {
+		copy(b, fmt.Sprintf("%0*o\x00", len(b)-1, n))
+	}
+
+	var data []byte
+	for _, f := range frags {
+		data = append(data, contents[f.offset:f.offset+f.length]...)
+	}
+
+	blk := make([]byte, _blockSize)
+	copy(blk[0:100], name)
+	octal(blk[100:108], mode)
+	octal(blk[108:116], uid)
+	octal(blk[116:124], 0)
+	octal(blk[124:136], int64(len(data)))
+	octal(blk[136:148], 0)
+	blk[156] = tar.TypeGNUSparse
+	copy(blk[257:265], "ustar  \x00")
+	for i, f := range frags {
+		octal(blk[386+i*24:398+i*24], f.offset)
+		octal(blk[398+i*24:410+i*24], f.length)
+	}
+	octal(blk[483:495], int64(len(contents)))
+
+	copy(blk[148:156], "        ")
+	var sum int64
+	for _, c := range blk {
+		sum += int64(c)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	blk = append(blk, data...)
+	if rem := len(data) % _blockSize; rem != 0 {
+		blk = append(blk, make([]byte, _blockSize-rem)...)
+	}
+	return blk
+}
+
+// writerAt is an in-memory io.WriterAt
+type writerAt struct {
+	buf []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
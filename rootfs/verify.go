@@ -0,0 +1,226 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// Verify compares the directory root, where the image was extracted, with
+// the flattened image, and returns the paths that differ, sorted by path,
+// like Diff does between two images: Old is the entry in the image, and New
+// in root. A path that is only in root is ChangeAdded, and one that is only
+// in the image is ChangeRemoved. Hardlinks in the image are compared as the
+// files they link to, directories that are only implied by the files in them
+// are not compared, and neither is root itself.
+//
+// Paths that match one of the ignore patterns, or are in a directory that
+// does, are skipped on both sides. The patterns are like in WithExclude,
+// e.g. "/var/log" or "/tmp/**/*.pid".
+//
+// Only WithWarnings, WithStrict and WithLayers apply.
+func Verify(rd io.ReadSeeker, root string, ignore []string, opts ...Option) ([]Change, error) {
+	return VerifyContext(context.Background(), rd, root, ignore, opts...)
+}
+
+// VerifyContext is like Verify, but stops when ctx is done, like
+// FlattenContext.
+func VerifyContext(
+	ctx context.Context,
+	rd io.ReadSeeker,
+	root string,
+	ignore []string,
+	opts ...Option,
+) ([]Change, error) {
+	f, err := newFilter(nil, ignore)
+	if err != nil {
+		return nil, err
+	}
+	img, err := openImage(ctx, rd, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := img.diffEntries(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// implied are the directories that are only implied by the entries of
+	// the image.
+	implied := map[string]struct{}{}
+	for name := range entries {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := entries[dir]; !ok {
+				implied[dir] = struct{}{}
+			}
+		}
+	}
+	delete(entries, ".")
+
+	var ret []Change
+	// hash are the regular files whose contents are still to be compared,
+	// with the hashes of the files in root, and modified the fields that
+	// differ otherwise. headers are the headers of the files in root.
+	hash := map[string][sha256.Size]byte{}
+	modified := map[string][]string{}
+	headers := map[string]*tar.Header{}
+	// seen are the entries of the image that are in root.
+	seen := map[string]struct{}{}
+	err = filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == "." {
+			return nil
+		}
+		if !f.match(name, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		hdr, err := fileHeader(fpath)
+		if err != nil {
+			return err
+		}
+		e, ok := entries[name]
+		if !ok {
+			if _, ok := implied[name]; !ok || hdr.Typeflag != tar.TypeDir {
+				ret = append(ret, Change{Path: name, Kind: ChangeAdded, New: hdr})
+			}
+			return nil
+		}
+		seen[name] = struct{}{}
+		headers[name] = hdr
+		old := e.hdr
+		if old.Typeflag == tar.TypeLink {
+			if target, ok := entries[cleanName(old.Linkname)]; ok {
+				old = target.hdr
+			}
+		}
+		fields := diffHeaders(old, hdr)
+		if hdr.Typeflag == tar.TypeSymlink {
+			fields = without(fields, FieldMode)
+		}
+		if len(fields) > 0 {
+			modified[name] = fields
+		}
+		if isRegular(old) && isRegular(hdr) && old.Size == hdr.Size {
+			sum, err := hashFile(fpath)
+			if err != nil {
+				return err
+			}
+			hash[name] = sum
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, e := range entries {
+		if _, ok := seen[name]; !ok && f.match(name, e.hdr.Typeflag == tar.TypeDir) {
+			ret = append(ret, Change{Path: name, Kind: ChangeRemoved, Old: e.hdr})
+		}
+	}
+
+	if len(hash) > 0 {
+		// hardlinks are hashed as the files they link to.
+		want := map[string]struct{}{}
+		for name := range hash {
+			if hdr := entries[name].hdr; hdr.Typeflag == tar.TypeLink {
+				want[cleanName(hdr.Linkname)] = struct{}{}
+			} else {
+				want[name] = struct{}{}
+			}
+		}
+		sums, err := img.diffEntries(ctx, want)
+		if err != nil {
+			return nil, err
+		}
+		for name, sum := range hash {
+			e := sums[name]
+			if e.hdr.Typeflag == tar.TypeLink {
+				e = sums[cleanName(e.hdr.Linkname)]
+			}
+			if e.sum != sum {
+				modified[name] = append(modified[name], FieldContent)
+			}
+		}
+	}
+	for name, fields := range modified {
+		ret = append(ret, Change{
+			Path:   name,
+			Kind:   ChangeModified,
+			Fields: fields,
+			Old:    entries[name].hdr,
+			New:    headers[name],
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret, nil
+}
+
+// fileHeader returns the tar header of the file at fpath, as tar would
+// archive it.
+func fileHeader(fpath string) (*tar.Header, error) {
+	fi, err := os.Lstat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(fpath); err != nil {
+			return nil, err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fpath, err)
+	}
+	return hdr, nil
+}
+
+// hashFile returns the SHA-256 of the contents of the file at fpath.
+func hashFile(fpath string) (_ [sha256.Size]byte, _err error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(fpath)
+	if err != nil {
+		return sum, err
+	}
+	defer func() {
+		_err = errors.Join(_err, f.Close())
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// without returns fields without field.
+func without(fields []string, field string) []string {
+	var ret []string
+	for _, f := range fields {
+		if f != field {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
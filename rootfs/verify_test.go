@@ -0,0 +1,229 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []struct{ name, contents string }{
+		{"etc/passwd", "root"},
+		{"etc/hosts", "127.0.0.1"},
+		{"etc/motd", "hi"},
+		{"var/log/messages", "boot"},
+	} {
+		fpath := filepath.Join(root, f.name)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fpath, []byte(f.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("busybox", filepath.Join(root, "sh")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the image is made from root, so they are the same to begin with.
+	image := tarball{
+		file{Name: "blobs/sha256/aaa", Contents: tarDir(t, root)},
+		manifest{"blobs/sha256/aaa"},
+	}
+	verify := func(ignore ...string) []string {
+		t.Helper()
+		changes, err := Verify(bytes.NewReader(image.Buffer().Bytes()), root, ignore)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		var ret []string
+		for _, c := range changes {
+			ret = append(ret, fmt.Sprintf("%s %s %v", c.Path, c.Kind, c.Fields))
+		}
+		return ret
+	}
+	if got := verify(); got != nil {
+		t.Fatalf("expected no changes, got %q", got)
+	}
+
+	for _, fn := range []func() error{
+		func() error { return os.WriteFile(filepath.Join(root, "etc/hosts"), []byte("127.0.0.2"), 0644) },
+		func() error { return os.Chmod(filepath.Join(root, "etc/motd"), 0600) },
+		func() error { return os.Remove(filepath.Join(root, "etc/passwd")) },
+		func() error { return os.WriteFile(filepath.Join(root, "etc/new"), nil, 0644) },
+		func() error {
+			return os.WriteFile(filepath.Join(root, "var/log/messages"), []byte("boot\nlogin"), 0644)
+		},
+		func() error { return os.Remove(filepath.Join(root, "sh")) },
+		func() error { return os.Symlink("bash", filepath.Join(root, "sh")) },
+	} {
+		if err := fn(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"etc/hosts modified [content]",
+		"etc/motd modified [mode]",
+		"etc/new added []",
+		"etc/passwd removed []",
+		"sh modified [linkname]",
+	}
+	if got := verify("/var/log"); !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+	want = append(want[:4:4], "sh modified [linkname]", "var/log/messages modified [content]")
+	if got := verify("/etc/*.d"); !reflect.DeepEqual(want, got) {
+		t.Errorf("want != got: %q != %q", want, got)
+	}
+
+	if _, err := Verify(bytes.NewReader(image.Buffer().Bytes()), root, []string{"["}); err == nil {
+		t.Errorf("expected an error for a bad pattern")
+	}
+}
+
+func TestVerifyFlatten(t *testing.T) {
+	type entry struct {
+		hdr      tar.Header
+		contents string
+	}
+	layer := func(entries ...entry) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, e := range entries {
+			e.hdr.Uid, e.hdr.Gid = os.Getuid(), os.Getgid()
+			e.hdr.Size = int64(len(e.contents))
+			if err := tw.WriteHeader(&e.hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(tw, e.contents); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return &buf
+	}
+	image := tarball{
+		file{Name: "blobs/sha256/aaa", Contents: layer(
+			entry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "tmp/", Mode: 01777}},
+			entry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "usr/", Mode: 0755}},
+			entry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "usr/bin/", Mode: 0755}},
+			entry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/su", Mode: 04755}, contents: "su"},
+			entry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/old", Mode: 0755}, contents: "old"},
+			entry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/busybox", Mode: 0755}, contents: "bb"},
+		)},
+		file{Name: "blobs/sha256/bbb", Contents: layer(
+			entry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/.wh.old"}},
+			entry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "usr/sbin/", Mode: 02755}},
+			entry{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "usr/bin/ls", Linkname: "usr/bin/busybox"}},
+			entry{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "usr/bin/sh", Linkname: "busybox", Mode: 0777}},
+		)},
+		manifest{"blobs/sha256/aaa", "blobs/sha256/bbb"},
+	}.Buffer().Bytes()
+
+	var out bytes.Buffer
+	if err := Flatten(bytes.NewReader(image), &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	root := t.TempDir()
+	extractTar(t, &out, root)
+
+	changes, err := Verify(bytes.NewReader(image), root, nil)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	for _, c := range changes {
+		t.Errorf("unexpected change: %s %s %v", c.Path, c.Kind, c.Fields)
+	}
+}
+
+// extractTar extracts the tarball in r to root, like tar -xp does.
+func extractTar(t *testing.T, r io.Reader, root string) {
+	t.Helper()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fpath := filepath.Join(root, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(fpath, 0755)
+		case tar.TypeReg:
+			var f *os.File
+			if f, err = os.Create(fpath); err == nil {
+				_, err = io.Copy(f, tr)
+				err = errors.Join(err, f.Close())
+			}
+		case tar.TypeLink:
+			err = os.Link(filepath.Join(root, filepath.FromSlash(hdr.Linkname)), fpath)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, fpath)
+		default:
+			t.Fatalf("%s: unexpected type %c", hdr.Name, hdr.Typeflag)
+		}
+		if err == nil && hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeLink {
+			err = os.Chmod(fpath, hdr.FileInfo().Mode())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tarDir tars the files in root, like tar does.
+func tarDir(t *testing.T, root string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err := filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, fpath)
+		if err != nil || rel == "." {
+			return err
+		}
+		hdr, err := fileHeader(fpath)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(fpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
@@ -0,0 +1,39 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+)
+
+// WalkFunc is called by Walk for every entry of the flattened image. r reads
+// the contents of regular files. Both hdr and r are only valid until WalkFunc
+// returns. A non-nil error stops the walk and is returned by Walk.
+type WalkFunc func(hdr *tar.Header, r io.Reader) error
+
+// Walk calls fn for every entry of the flattened image, in the order Flatten
+// would write them, without writing a tarball. The whiteout and override
+// rules are the same as in Flatten.
+//
+// The headers are passed as they are in the layers: options that shape the
+// output tarball, like WithIDMap, WithResolvedNames, WithMtree or
+// WithExclude, are ignored.
+func Walk(rd io.ReadSeeker, fn WalkFunc, opts ...Option) error {
+	return WalkContext(context.Background(), rd, fn, opts...)
+}
+
+// WalkContext is like Walk, but stops when ctx is done, like FlattenContext.
+func WalkContext(
+	ctx context.Context,
+	rd io.ReadSeeker,
+	fn WalkFunc,
+	opts ...Option,
+) error {
+	img, err := openImage(ctx, rd, newOptions(opts))
+	if err != nil {
+		return err
+	}
+	return img.walk(ctx, func(_ int, hdr *tar.Header, r *entryReader) error {
+		return fn(hdr, r)
+	})
+}
@@ -0,0 +1,71 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs/internal/tartest"
+)
+
+func TestWalk(t *testing.T) {
+	image := tarball{
+		file{Name: "blobs/layer0/layer", Contents: tarball{
+			dir{Name: "etc"},
+			file{Name: "etc/a", Contents: bytes.NewBufferString("a0")},
+			file{Name: "etc/b", Contents: bytes.NewBufferString("b0")},
+		}.Buffer()},
+		file{Name: "blobs/layer1/layer", Contents: tarball{
+			file{Name: "etc/a", Contents: bytes.NewBufferString("a1")},
+			hardlink{Name: "etc/.wh.b"},
+		}.Buffer()},
+		manifest{"blobs/layer0/layer", "blobs/layer1/layer"},
+	}
+
+	t.Run("same as Flatten", func(t *testing.T) {
+		var got []extractable
+		err := Walk(bytes.NewReader(image.Buffer().Bytes()), func(hdr *tar.Header, r io.Reader) error {
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				got = append(got, dir{Name: hdr.Name, UID: hdr.Uid})
+			case tar.TypeReg:
+				var buf bytes.Buffer
+				if _, err := io.Copy(&buf, r); err != nil {
+					return err
+				}
+				got = append(got, file{Name: hdr.Name, UID: hdr.Uid, Contents: &buf})
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		var out bytes.Buffer
+		if err := Flatten(bytes.NewReader(image.Buffer().Bytes()), &out); err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		want := tartest.Extract(t, &out)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("want != got: %v != %v", want, got)
+		}
+	})
+
+	t.Run("error stops the walk", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var n int
+		err := Walk(bytes.NewReader(image.Buffer().Bytes()), func(*tar.Header, io.Reader) error {
+			n++
+			return errStop
+		})
+		if !errors.Is(err, errStop) {
+			t.Errorf("expected errStop, got %v", err)
+		}
+		if n != 1 {
+			t.Errorf("expected 1 call, got %d", n)
+		}
+	})
+}
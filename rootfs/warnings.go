@@ -0,0 +1,64 @@
+package rootfs
+
+import "fmt"
+
+// WarningKind classifies warnings.
+type WarningKind string
+
+const (
+	// WarningEmptyLayer is a layer without entries.
+	WarningEmptyLayer WarningKind = "empty-layer"
+	// WarningUnreferencedBlob is a blob that is not referenced by the
+	// manifest, e.g. a leftover of a hand-edited image.
+	WarningUnreferencedBlob WarningKind = "unreferenced-blob"
+	// WarningLowestLayerWhiteout is a whiteout in the first layer, which
+	// has nothing to remove. It is copied to the output as is.
+	WarningLowestLayerWhiteout WarningKind = "lowest-layer-whiteout"
+	// WarningUnknownType is an entry of a type that undocker does not
+	// know. It is copied to the output as is.
+	WarningUnknownType WarningKind = "unknown-type"
+	// WarningDuplicateEntry is an entry that appears more than once in a
+	// layer. The last one wins.
+	WarningDuplicateEntry WarningKind = "duplicate-entry"
+	// WarningEscapingLink is a symlink or a hardlink that points outside
+	// of the directory given to WithRoot. It is left out of the output.
+	WarningEscapingLink WarningKind = "escaping-link"
+)
+
+// Warning is a non-fatal anomaly found in an image. With WithStrict, it is
+// returned as an error.
+type Warning struct {
+	Kind WarningKind
+	// Layer is the layer name as listed in manifest.json, or the blob name
+	// for WarningUnreferencedBlob.
+	Layer string
+	// Name is the offending entry within the layer, if any.
+	Name string
+	// Msg describes the anomaly.
+	Msg string
+}
+
+// String stringifies a warning
+func (w Warning) String() string {
+	if w.Name == "" {
+		return fmt.Sprintf("%s: %s", w.Layer, w.Msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", w.Layer, w.Name, w.Msg)
+}
+
+func (w Warning) Error() string {
+	return w.String()
+}
+
+// warn reports a warning. In strict mode, the warning is returned as an
+// error, which is to stop flattening.
+func (o *options) warn(kind WarningKind, layer, name, msg string) error {
+	w := Warning{Kind: kind, Layer: layer, Name: name, Msg: msg}
+	if o.warnings != nil {
+		o.warnings(w)
+	}
+	if o.strict {
+		return w
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package rootfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	layer0 := tarball{
+		file{Name: "a"},
+		file{Name: "a"},
+	}.Buffer()
+
+	tests := []struct {
+		name      string
+		image     tarball
+		strict    bool
+		wantKinds []WarningKind
+		wantErr   string
+	}{
+		{
+			name: "config and OCI index are referenced",
+			image: tarball{
+				file{Name: "blobs/sha256/layer0", Contents: tarball{file{Name: "a"}}.Buffer()},
+				file{Name: "blobs/sha256/config", Contents: bytes.NewBufferString("{}")},
+				file{Name: "blobs/sha256/index", Contents: bytes.NewBufferString("{}")},
+				file{Name: "blobs/sha256/stray", Contents: bytes.NewBufferString("{}")},
+				file{Name: "index.json", Contents: bytes.NewBufferString(
+					`{"manifests":[{"digest":"sha256:index"}]}`)},
+				file{Name: "manifest.json", Contents: bytes.NewBufferString(
+					`[{"Config":"blobs/sha256/config","Layers":["blobs/sha256/layer0"]}]`)},
+			},
+			wantKinds: []WarningKind{WarningUnreferencedBlob},
+		},
+		{
+			name: "lenient",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: layer0},
+				manifest{"blobs/layer0/layer"},
+			},
+			wantKinds: []WarningKind{WarningDuplicateEntry},
+		},
+		{
+			name: "strict",
+			image: tarball{
+				file{Name: "blobs/layer0/layer", Contents: layer0},
+				manifest{"blobs/layer0/layer"},
+			},
+			strict:    true,
+			wantKinds: []WarningKind{WarningDuplicateEntry},
+			wantErr:   "blobs/layer0/layer: a: duplicate entry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kinds []WarningKind
+			opts := []Option{WithWarnings(func(w Warning) {
+				kinds = append(kinds, w.Kind)
+			})}
+			if tt.strict {
+				opts = append(opts, WithStrict())
+			}
+			in := bytes.NewReader(tt.image.Buffer().Bytes())
+			err := FlattenWithOptions(in, io.Discard, opts...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected nil error, got %v", err)
+				}
+			} else {
+				var w Warning
+				if !errors.As(err, &w) || w.Kind != WarningDuplicateEntry {
+					t.Fatalf("expected a Warning, got %#v", err)
+				}
+				if err.Error() != tt.wantErr {
+					t.Errorf("want != got: %s != %s", tt.wantErr, err.Error())
+				}
+			}
+			if !reflect.DeepEqual(tt.wantKinds, kinds) {
+				t.Errorf("want warnings != got: %q != %q", tt.wantKinds, kinds)
+			}
+		})
+	}
+}
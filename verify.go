@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+const _verifyUsage = `Compare a directory where an image was extracted with the image, and print
+the paths that are added (A), removed (D) or modified (M) in the directory.
+Exit with an error if any are.
+
+Arguments:
+  <infile>:  Input Docker container. Tarball.
+  <dir>:     Directory to verify.
+
+Options:
+  --ignore <pattern>
+             Skip the paths that match pattern, and everything in the
+             directories that do, e.g. /var/log or /tmp/**/*.pid. Can be
+             repeated.
+  --json
+             Print a JSON object per path instead of text.
+` + _imageFlagsUsage
+
+var _verify = &subcommand{
+	name:    "verify",
+	args:    "<infile> <dir>",
+	nargs:   2,
+	summary: "Check a directory for drift from an image.",
+	usage:   _verifyUsage,
+	setup: func(flags *flag.FlagSet) func([]string) error {
+		var imgFlags imageFlags
+		var ignore stringsFlag
+		c := &verifyCommand{verify: rootfs.Verify, Stdout: os.Stdout}
+		flags.Var(&ignore, "ignore", "")
+		flags.BoolVar(&c.json, "json", false, "")
+		imgFlags.register(flags)
+		return func(args []string) error {
+			c.ignore = ignore
+			c.options = imgFlags.options()
+			return c.execute(args[0], args[1])
+		}
+	},
+}
+
+// verifyCommand prints the drift of a directory from an image to Stdout.
+type verifyCommand struct {
+	verify  func(io.ReadSeeker, string, []string, ...rootfs.Option) ([]rootfs.Change, error)
+	ignore  []string
+	options []rootfs.Option
+	json    bool
+	Stdout  io.Writer
+}
+
+func (c *verifyCommand) execute(infile, dir string) (_err error) {
+	rd, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_err = errors.Join(_err, rd.Close())
+	}()
+
+	changes, err := c.verify(rd, dir, c.ignore, c.options...)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(c.Stdout)
+	for _, ch := range changes {
+		ch.Path = "/" + ch.Path
+		if c.json {
+			err = enc.Encode(ch)
+		} else {
+			err = printChange(c.Stdout, ch)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("%s differs from the image", dir)
+	}
+	return nil
+}
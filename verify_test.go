@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"git.jakstys.lt/motiejus/undocker/rootfs"
+)
+
+func TestVerify(t *testing.T) {
+	infile := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(infile, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		changes []rootfs.Change
+		json    bool
+		want    string
+		wantErr string
+	}{
+		{
+			name: "no drift",
+		},
+		{
+			name: "drift",
+			changes: []rootfs.Change{
+				{Path: "etc/hosts", Kind: rootfs.ChangeModified, Fields: []string{rootfs.FieldContent}},
+				{Path: "etc/passwd", Kind: rootfs.ChangeRemoved},
+			},
+			want:    "M /etc/hosts (content)\nD /etc/passwd\n",
+			wantErr: "rootfs differs from the image",
+		},
+		{
+			name: "json",
+			changes: []rootfs.Change{
+				{Path: "etc/new", Kind: rootfs.ChangeAdded},
+			},
+			json:    true,
+			want:    `{"path":"/etc/new","kind":"added"}` + "\n",
+			wantErr: "rootfs differs from the image",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotIgnore []string
+			verify := func(_ io.ReadSeeker, _ string, ignore []string, _ ...rootfs.Option) ([]rootfs.Change, error) {
+				gotIgnore = ignore
+				return tt.changes, nil
+			}
+			var stdout bytes.Buffer
+			c := &verifyCommand{
+				verify: verify,
+				ignore: []string{"/var/log"},
+				json:   tt.json,
+				Stdout: &stdout,
+			}
+			err := c.execute(infile, "rootfs")
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+			if got := stdout.String(); got != tt.want {
+				t.Errorf("want != got:\n%s\n%s", tt.want, got)
+			}
+			if !reflect.DeepEqual(gotIgnore, []string{"/var/log"}) {
+				t.Errorf("unexpected ignore patterns: %q", gotIgnore)
+			}
+		})
+	}
+}